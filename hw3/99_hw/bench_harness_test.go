@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"user/user"
+
+	"github.com/mailru/easyjson"
+)
+
+// -----
+// go test -run TestBenchHarness -harness -harness.users 5000 -harness.out bench.json
+// go test -run TestBenchHarness -harness -harness.baseline bench_baseline.json
+
+var (
+	harness          = flag.Bool("harness", false, "run the benchmark regression harness")
+	harnessUsers     = flag.Int("harness.users", 1000, "synthetic dataset size")
+	harnessSkew      = flag.Float64("harness.skew", 1, "browser popularity skew, 0 - uniform")
+	harnessSeed      = flag.Int64("harness.seed", 1, "dataset seed")
+	harnessOut       = flag.String("harness.out", "", "write the report as json to this file")
+	harnessBaseline  = flag.String("harness.baseline", "", "compare with a previously written report")
+	harnessTolerance = flag.Float64("harness.tolerance", 0.2, "allowed regression against the baseline, 0.2 = 20%")
+)
+
+type DatasetSpec struct {
+	Users int     `json:"users"`
+	Skew  float64 `json:"skew"`
+	Seed  int64   `json:"seed"`
+}
+
+type BenchResult struct {
+	Name        string `json:"name"`
+	NsPerOp     int64  `json:"ns_per_op"`
+	AllocsPerOp int64  `json:"allocs_per_op"`
+	BytesPerOp  int64  `json:"bytes_per_op"`
+}
+
+type BenchReport struct {
+	Dataset DatasetSpec   `json:"dataset"`
+	Results []BenchResult `json:"results"`
+}
+
+// searchImpl prepares a search over path, preparation is not measured
+type searchImpl struct {
+	Name    string
+	Prepare func(path string) (func(out io.Writer) error, error)
+}
+
+// the first one is the reference the others are compared with
+var searchImpls = []searchImpl{
+	{"slow", func(path string) (func(out io.Writer) error, error) {
+		return func(out io.Writer) error {
+			return slowSearchFile(out, path)
+		}, nil
+	}},
+	{"fast", func(path string) (func(out io.Writer) error, error) {
+		return func(out io.Writer) error {
+			_, err := FastSearchFile(out, path, SearchOptions{})
+			return err
+		}, nil
+	}},
+	{"indexed", func(path string) (func(out io.Writer) error, error) {
+		ix, err := BuildIndex(path, path+".idx")
+		if err != nil {
+			return nil, err
+		}
+		return func(out io.Writer) error {
+			return IndexedSearch(out, ix, nil)
+		}, nil
+	}},
+}
+
+var syntheticBrowsers = []string{
+	"Mozilla/5.0 (Linux; U; Android %d.0; en-us; GT-I9%03d Build/IMM76D) AppleWebKit/534.30 Mobile Safari/534.30",
+	"Mozilla/5.0 (compatible; MSIE %d.0; Windows NT 6.1; Trident/%d.0)",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/%d.0.%d Safari/537.36",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:%d.0) Gecko/20100101 Firefox/%d.0",
+}
+
+// generateDataset writes spec.Users json lines without a trailing newline, like data/users.txt.
+// Browsers are picked from a pool of 400 user agents, with skew > 0 popularity follows Zipf.
+func generateDataset(w io.Writer, spec DatasetSpec) error {
+	rnd := rand.New(rand.NewSource(spec.Seed))
+
+	pool := make([]string, 400)
+	for i := range pool {
+		pool[i] = fmt.Sprintf(syntheticBrowsers[i%len(syntheticBrowsers)], i/len(syntheticBrowsers), i)
+	}
+
+	pick := func() string { return pool[rnd.Intn(len(pool))] }
+	if spec.Skew > 0 {
+		zipf := rand.NewZipf(rnd, 1+spec.Skew, 1, uint64(len(pool)-1))
+		pick = func() string { return pool[zipf.Uint64()] }
+	}
+
+	for i := 0; i < spec.Users; i++ {
+		u := user.User{
+			Company: fmt.Sprintf("Company%d", rnd.Intn(50)),
+			Country: fmt.Sprintf("Country%d", rnd.Intn(20)),
+			Email:   fmt.Sprintf("user%d@company%d.com", i, rnd.Intn(50)),
+			Job:     fmt.Sprintf("Job%d", rnd.Intn(30)),
+			Name:    fmt.Sprintf("User %d", i),
+			Phone:   fmt.Sprintf("%03d-%03d-%02d-%02d", rnd.Intn(1000), rnd.Intn(1000), rnd.Intn(100), rnd.Intn(100)),
+		}
+		for n := 1 + rnd.Intn(5); n > 0; n-- {
+			u.Browsers = append(u.Browsers, pick())
+		}
+
+		line, err := easyjson.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			line = append([]byte("\n"), line...)
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDataset(t *testing.T, spec DatasetSpec) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "users.txt")
+	buf := new(bytes.Buffer)
+	if err := generateDataset(buf, spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// prepareImpls checks that every implementation prints exactly what the reference does
+func prepareImpls(t *testing.T, path string) []func(out io.Writer) error {
+	t.Helper()
+	runs := make([]func(out io.Writer) error, len(searchImpls))
+	expected := ""
+	for i, impl := range searchImpls {
+		run, err := impl.Prepare(path)
+		if err != nil {
+			t.Fatalf("%s: %s", impl.Name, err)
+		}
+		out := new(bytes.Buffer)
+		if err := run(out); err != nil {
+			t.Fatalf("%s: %s", impl.Name, err)
+		}
+		if i == 0 {
+			expected = out.String()
+		} else if out.String() != expected {
+			t.Errorf("%s results not match %s\nGot:\n%v\nExpected:\n%v",
+				impl.Name, searchImpls[0].Name, out.String(), expected)
+		}
+		runs[i] = run
+	}
+	return runs
+}
+
+func TestSearchImplementationsAgree(t *testing.T) {
+	for _, skew := range []float64{0, 1, 3} {
+		prepareImpls(t, writeDataset(t, DatasetSpec{Users: 300, Skew: skew, Seed: 42}))
+	}
+}
+
+func TestBenchHarness(t *testing.T) {
+	if !*harness {
+		t.Skip("run with -harness")
+	}
+
+	spec := DatasetSpec{Users: *harnessUsers, Skew: *harnessSkew, Seed: *harnessSeed}
+	runs := prepareImpls(t, writeDataset(t, spec))
+	if t.Failed() {
+		return
+	}
+
+	report := BenchReport{Dataset: spec}
+	for i, impl := range searchImpls {
+		run := runs[i]
+		res := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				run(ioutil.Discard)
+			}
+		})
+		report.Results = append(report.Results, BenchResult{
+			Name:        impl.Name,
+			NsPerOp:     res.NsPerOp(),
+			AllocsPerOp: res.AllocsPerOp(),
+			BytesPerOp:  res.AllocedBytesPerOp(),
+		})
+		t.Logf("%-8s %12d ns/op %12d B/op %8d allocs/op", impl.Name, res.NsPerOp(), res.AllocedBytesPerOp(), res.AllocsPerOp())
+	}
+
+	if *harnessOut != "" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if err := ioutil.WriteFile(*harnessOut, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if *harnessBaseline != "" {
+		baseline, err := readBenchReport(*harnessBaseline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range compareBench(baseline, &report, *harnessTolerance) {
+			t.Error(problem)
+		}
+	}
+}
+
+func readBenchReport(path string) (*BenchReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	report := &BenchReport{}
+	return report, json.NewDecoder(file).Decode(report)
+}
+
+// compareBench lists every metric that got worse than baseline by more than tolerance
+func compareBench(baseline, current *BenchReport, tolerance float64) []string {
+	problems := make([]string, 0)
+	if baseline.Dataset != current.Dataset {
+		problems = append(problems, fmt.Sprintf("dataset differs from baseline: %+v vs %+v", current.Dataset, baseline.Dataset))
+		return problems
+	}
+
+	prev := make(map[string]BenchResult)
+	for _, r := range baseline.Results {
+		prev[r.Name] = r
+	}
+
+	for _, cur := range current.Results {
+		old, ok := prev[cur.Name]
+		if !ok {
+			continue
+		}
+		metrics := []struct {
+			name     string
+			old, cur int64
+		}{
+			{"ns/op", old.NsPerOp, cur.NsPerOp},
+			{"B/op", old.BytesPerOp, cur.BytesPerOp},
+			{"allocs/op", old.AllocsPerOp, cur.AllocsPerOp},
+		}
+		for _, m := range metrics {
+			if float64(m.cur) > float64(m.old)*(1+tolerance) {
+				problems = append(problems, fmt.Sprintf("%s: %s regressed %d -> %d (+%.0f%%)",
+					cur.Name, m.name, m.old, m.cur, 100*(float64(m.cur)/float64(m.old)-1)))
+			}
+		}
+	}
+	return problems
+}
+
+func TestCompareBench(t *testing.T) {
+	spec := DatasetSpec{Users: 10}
+	baseline := &BenchReport{Dataset: spec, Results: []BenchResult{{"fast", 1000, 10, 100}}}
+	current := &BenchReport{Dataset: spec, Results: []BenchResult{{"fast", 1100, 13, 100}}}
+
+	problems := compareBench(baseline, current, 0.2)
+	if len(problems) != 1 || !strings.Contains(problems[0], "allocs/op") {
+		t.Errorf("expected allocs/op regression only, got %v", problems)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"user/user"
+	"xmlstream"
+
+	"github.com/mailru/easyjson"
+)
+
+// Mapping tells where every user.User field (by its json name) comes from: child
+// elements of the record by name, "@attr" for attributes, "a/b" for nested elements.
+// Several sources are joined with a space, browsers collects every value of them.
+type Mapping map[string][]string
+
+type Preset struct {
+	Path    string // slash separated element names from the root to the record
+	Mapping Mapping
+}
+
+var presets = map[string]Preset{
+	// hw3/xml: <users><user id="1"><login/><name/><browser/></user></users>
+	"hw3": {
+		Path: "users/user",
+		Mapping: Mapping{
+			"browsers": {"browser"},
+			"company":  {"company"},
+			"country":  {"country"},
+			"email":    {"email"},
+			"job":      {"job"},
+			"name":     {"name"},
+			"phone":    {"phone"},
+		},
+	},
+	// hw4 dataset.xml: <root><row><first_name/><last_name/>...</row></root>
+	"hw4": {
+		Path: "root/row",
+		Mapping: Mapping{
+			"company": {"company"},
+			"email":   {"email"},
+			"name":    {"first_name", "last_name"},
+			"phone":   {"phone"},
+		},
+	},
+}
+
+var userFields = map[string]func(u *user.User, values []string){
+	"browsers": func(u *user.User, values []string) { u.Browsers = append(u.Browsers, values...) },
+	"company":  func(u *user.User, values []string) { u.Company = strings.Join(values, " ") },
+	"country":  func(u *user.User, values []string) { u.Country = strings.Join(values, " ") },
+	"email":    func(u *user.User, values []string) { u.Email = strings.Join(values, " ") },
+	"job":      func(u *user.User, values []string) { u.Job = strings.Join(values, " ") },
+	"name":     func(u *user.User, values []string) { u.Name = strings.Join(values, " ") },
+	"phone":    func(u *user.User, values []string) { u.Phone = strings.Join(values, " ") },
+}
+
+// parseMapping applies "name=first_name+last_name,email=@mail" on top of base,
+// an empty source list drops the field
+func parseMapping(spec string, base Mapping) (Mapping, error) {
+	result := make(Mapping, len(base))
+	for field, sources := range base {
+		result[field] = sources
+	}
+	if spec == "" {
+		return result, nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		eq := strings.IndexByte(item, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("bad mapping %q, expected field=source+source", item)
+		}
+		field := strings.TrimSpace(item[:eq])
+		if userFields[field] == nil {
+			return nil, fmt.Errorf("unknown user field %q", field)
+		}
+		sources := []string{}
+		for _, source := range strings.Split(item[eq+1:], "+") {
+			if source = strings.TrimSpace(source); source != "" {
+				sources = append(sources, source)
+			}
+		}
+		if len(sources) == 0 {
+			delete(result, field)
+			continue
+		}
+		result[field] = sources
+	}
+	return result, nil
+}
+
+// Convert streams records at p.Path from r and writes them to w as JSON lines,
+// one user.User per line. It returns the number of records written.
+func Convert(r io.Reader, w io.Writer, p Preset) (int, error) {
+	out := bufio.NewWriter(w)
+	fields := make([]string, 0, len(p.Mapping))
+	for field := range p.Mapping {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	count := 0
+	values := record{}
+	err := xmlstream.New(r, p.Path).Each(&values, func() error {
+		u := &user.User{}
+		for _, field := range fields {
+			collected := []string{}
+			for _, source := range p.Mapping[field] {
+				collected = append(collected, values[source]...)
+			}
+			if len(collected) > 0 {
+				userFields[field](u, collected)
+			}
+		}
+
+		if _, err := easyjson.MarshalToWriter(u, out); err != nil {
+			return err
+		}
+		out.WriteByte('\n')
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, out.Flush()
+}
+
+// record holds attributes and the trimmed text of every element inside
+// the record, keyed by the path relative to the record
+type record map[string][]string
+
+func (rec *record) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	values := make(record)
+	for _, attr := range start.Attr {
+		values["@"+attr.Name.Local] = append(values["@"+attr.Name.Local], attr.Value)
+	}
+
+	path := []string{}
+	texts := []*strings.Builder{}
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			path = append(path, tok.Name.Local)
+			texts = append(texts, &strings.Builder{})
+			key := strings.Join(path, "/")
+			for _, attr := range tok.Attr {
+				values[key+"/@"+attr.Name.Local] = append(values[key+"/@"+attr.Name.Local], attr.Value)
+			}
+		case xml.CharData:
+			if len(texts) > 0 {
+				texts[len(texts)-1].Write(tok)
+			}
+		case xml.EndElement:
+			if len(path) == 0 {
+				*rec = values
+				return nil
+			}
+			key := strings.Join(path, "/")
+			if text := strings.TrimSpace(texts[len(texts)-1].String()); text != "" {
+				values[key] = append(values[key], text)
+			}
+			path, texts = path[:len(path)-1], texts[:len(texts)-1]
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// xml2jsonl converts XML user dumps into JSON lines of user.User, the input of
+// FastSearch and the index:
+//
+//	go run ./cmd/xml2jsonl -preset hw4 -in ../../hw4/99_hw/dataset.xml -out data/hw4.txt
+//	go run ./cmd/xml2jsonl -preset hw3 -map "job=@id,browsers=browser+agent" < users.xml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	preset := flag.String("preset", "hw3", "input layout: "+strings.Join(names, ", "))
+	path := flag.String("path", "", "record elements, e.g. users/user, overrides the preset")
+	mapping := flag.String("map", "", "field=source+source,... on top of the preset; sources are element names, @attr or a/b")
+	in := flag.String("in", "", "input file, stdin by default")
+	out := flag.String("out", "", "output file, stdout by default")
+	flag.Parse()
+
+	if err := run(*preset, *path, *mapping, *in, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "xml2jsonl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(presetName, path, mapping, in, out string) error {
+	p, ok := presets[presetName]
+	if !ok {
+		return fmt.Errorf("unknown preset %q", presetName)
+	}
+	if path != "" {
+		p.Path = path
+	}
+	var err error
+	if p.Mapping, err = parseMapping(mapping, p.Mapping); err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if in != "" {
+		file, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		file, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := Convert(r, w, p)
+	fmt.Fprintf(os.Stderr, "%d users converted\n", count)
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"user/user"
+
+	"github.com/mailru/easyjson"
+)
+
+func convert(t *testing.T, input, preset, mapping string) []user.User {
+	p := presets[preset]
+	var err error
+	if p.Mapping, err = parseMapping(mapping, p.Mapping); err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	count, err := Convert(strings.NewReader(input), out, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []user.User{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		u := user.User{}
+		if err := easyjson.Unmarshal(scanner.Bytes(), &u); err != nil {
+			t.Fatalf("bad line %q: %s", scanner.Text(), err)
+		}
+		users = append(users, u)
+	}
+	if count != len(users) {
+		t.Errorf("reported %d users, written %d", count, len(users))
+	}
+	return users
+}
+
+func TestConvert(t *testing.T) {
+	hw3 := `<?xml version="1.0" encoding="utf-8"?>
+	<users>
+		<user id="1">
+			<login>user1</login>
+			<name>Василий Романов</name>
+			<browser>Mozilla/5.0 (Windows NT 10.0; Win64; x64)
+	</browser>
+			<browser>Opera/9.80</browser>
+		</user>
+		<user id="2"><login>user2</login></user>
+	</users>`
+
+	hw4 := `<?xml version="1.0" encoding="UTF-8" ?>
+	<root>
+	  <row>
+	    <id>0</id>
+	    <first_name>Boyd</first_name>
+	    <last_name>Wolf</last_name>
+	    <company>HOPELI</company>
+	    <email>boydwolf@hopeli.com</email>
+	    <phone>+1 (956) 593-2402</phone>
+	  </row>
+	</root>`
+
+	cases := []struct {
+		Input    string
+		Preset   string
+		Mapping  string
+		Expected []user.User
+	}{
+		{hw3, "hw3", "", []user.User{
+			{Name: "Василий Романов", Browsers: []string{"Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "Opera/9.80"}},
+			{},
+		}},
+		{hw3, "hw3", "job=@id,email=login,browsers=", []user.User{
+			{Name: "Василий Романов", Job: "1", Email: "user1"},
+			{Job: "2", Email: "user2"},
+		}},
+		{hw4, "hw4", "", []user.User{
+			{Name: "Boyd Wolf", Company: "HOPELI", Email: "boydwolf@hopeli.com", Phone: "+1 (956) 593-2402"},
+		}},
+	}
+
+	for caseNum, item := range cases {
+		users := convert(t, item.Input, item.Preset, item.Mapping)
+		if !reflect.DeepEqual(users, item.Expected) {
+			t.Errorf("[%d] wrong users\nGot:\n%#v\nExpected:\n%#v", caseNum, users, item.Expected)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	if _, err := parseMapping("login=login", presets["hw3"].Mapping); err == nil {
+		t.Errorf("expected an error for an unknown user field")
+	}
+	if _, err := parseMapping("name", presets["hw3"].Mapping); err == nil {
+		t.Errorf("expected an error for a mapping without =")
+	}
+
+	_, err := Convert(strings.NewReader("<users>\n<user><name>a</user>"), new(bytes.Buffer), presets["hw3"])
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected a syntax error with the line, got %v", err)
+	}
+}
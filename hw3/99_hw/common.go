@@ -13,18 +13,24 @@ import (
 
 const filePath string = "./data/users.txt"
 
-func SlowSearch(out io.Writer) {
-	file, err := os.Open(filePath)
+// SlowSearch is the reference implementation, a malformed line is returned as *LineError
+func SlowSearch(out io.Writer) error {
+	return slowSearchFile(out, filePath)
+}
+
+func slowSearchFile(out io.Writer, path string) error {
+	file, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer file.Close()
 
 	fileContents, err := ioutil.ReadAll(file)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	r := regexp.MustCompile("@")
+	masking := DefaultMasking()
 	seenBrowsers := []string{}
 	uniqueBrowsers := 0
 	foundUsers := ""
@@ -32,14 +38,16 @@ func SlowSearch(out io.Writer) {
 	lines := strings.Split(string(fileContents), "\n")
 
 	users := make([]map[string]interface{}, 0)
-	for _, line := range lines {
+	var offset int64
+	for i, line := range lines {
 		user := make(map[string]interface{})
 		// fmt.Printf("%v %v\n", err, line)
 		err := json.Unmarshal([]byte(line), &user)
 		if err != nil {
-			panic(err)
+			return &LineError{Line: i + 1, Offset: offset, Err: err}
 		}
 		users = append(users, user)
+		offset += int64(len(line)) + 1
 	}
 
 	for i, user := range users {
@@ -102,10 +110,11 @@ func SlowSearch(out io.Writer) {
 		}
 
 		// log.Println("Android and MSIE user:", user["name"], user["email"])
-		email := r.ReplaceAllString(user["email"].(string), " [at] ")
-		foundUsers += fmt.Sprintf("[%d] %s <%s>\n", i, user["name"], email)
+		masking.ApplyMap(user)
+		foundUsers += fmt.Sprintf("[%d] %s <%s>\n", i, user["name"], user["email"])
 	}
 
 	fmt.Fprintln(out, "found users:\n"+foundUsers)
 	fmt.Fprintln(out, "Total unique browsers", len(seenBrowsers))
+	return nil
 }
@@ -1,42 +1,45 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 	"user/user"
-
-	"github.com/mailru/easyjson"
 )
 
 // вам надо написать более быструю оптимальную этой функции
 func FastSearch(out io.Writer) {
-	file, err := os.Open(filePath)
-	if err != nil {
+	if _, err := FastSearchFile(out, filePath, SearchOptions{}); err != nil {
 		panic(err)
 	}
+}
 
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+type SearchOptions struct {
+	ParseOptions
+	// Masking is applied to every found user, DefaultMasking if nil
+	Masking *Masking
+}
 
-	users := make([]user.User, 0)
-	uniqBrowsers := make(map[string]bool)
+// FastSearchFile is FastSearch over an arbitrary file that doesn't panic on malformed lines.
+// Nothing is written to out if strict mode gives up.
+func FastSearchFile(out io.Writer, path string, opts SearchOptions) (*Diagnostics, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
 
-	for scanner.Scan() {
-		user := user.User{}
-		err := easyjson.Unmarshal([]byte(scanner.Text()), &user)
-		if err != nil {
-			panic(err)
-		}
-		users = append(users, user)
+	masking := opts.Masking
+	if masking == nil {
+		masking = DefaultMasking()
 	}
 
+	defer file.Close()
+
+	uniqBrowsers := make(map[string]bool)
 	builder := strings.Builder{}
 
-	for i, user := range users {
+	diag, err := scanUsers(file, opts.ParseOptions, func(i int, _ int64, user *user.User) {
 		hasAndroid := false
 		hasIe := false
 
@@ -60,13 +63,22 @@ func FastSearch(out io.Writer) {
 		}
 
 		if !(hasAndroid && hasIe) {
-			continue
+			return
 		}
 
-		email := strings.ReplaceAll(user.Email, "@", " [at] ")
-		builder.WriteString(fmt.Sprintf("[%d] %s <%s>\n", i, user.Name, email))
+		writeFound(&builder, i, user, masking)
+	})
+	if err != nil {
+		return diag, err
 	}
 
 	fmt.Fprintln(out, "found users:\n"+builder.String())
 	fmt.Fprintln(out, "Total unique browsers", len(uniqBrowsers))
+
+	return diag, nil
+}
+
+func writeFound(builder *strings.Builder, i int, user *user.User, masking *Masking) {
+	masking.Apply(user)
+	builder.WriteString(fmt.Sprintf("[%d] %s <%s>\n", i, user.Name, user.Email))
 }
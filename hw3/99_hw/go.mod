@@ -5,6 +5,9 @@ go 1.16
 require (
 	github.com/mailru/easyjson v0.7.7
 	user/user v0.0.0-00010101000000-000000000000
+	xmlstream v0.0.0-00010101000000-000000000000
 )
 
 replace user/user => ./user
+
+replace xmlstream => ../xml/xmlstream
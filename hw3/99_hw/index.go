@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"user/user"
+
+	"github.com/mailru/easyjson"
+)
+
+// tailSize - how many bytes of the indexed region are kept to detect a rewritten source
+const tailSize = 256
+
+// Posting points to a single record in the source file
+type Posting struct {
+	Line   int
+	Offset int64
+}
+
+// Index is an inverted index over users.txt.
+// Terms are keyed by "field:token", e.g. "browsers:android".
+type Index struct {
+	Source string
+	Size   int64 // how many bytes of Source are indexed
+	Lines  int
+	Tail   []byte
+	Terms  map[string][]Posting
+}
+
+// BuildIndex indexes source from scratch and saves the result to indexPath
+func BuildIndex(source, indexPath string) (*Index, error) {
+	ix := &Index{
+		Source: source,
+		Terms:  make(map[string][]Posting),
+	}
+	if err := ix.update(); err != nil {
+		return nil, err
+	}
+	return ix, ix.save(indexPath)
+}
+
+// OpenIndex loads the index from indexPath. If source was appended to since the index
+// was saved only the new records are indexed, if it was rewritten the index is rebuilt.
+func OpenIndex(source, indexPath string) (*Index, error) {
+	file, err := os.Open(indexPath)
+	if os.IsNotExist(err) {
+		return BuildIndex(source, indexPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ix := &Index{}
+	err = gob.NewDecoder(file).Decode(ix)
+	file.Close()
+	if err != nil || ix.Source != source {
+		return BuildIndex(source, indexPath)
+	}
+
+	fresh, err := ix.appendOnly()
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return BuildIndex(source, indexPath)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == ix.Size {
+		return ix, nil
+	}
+
+	if err := ix.update(); err != nil {
+		return nil, err
+	}
+	return ix, ix.save(indexPath)
+}
+
+// appendOnly checks that the indexed part of the source is still the same
+func (ix *Index) appendOnly() (bool, error) {
+	file, err := os.Open(ix.Source)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < ix.Size {
+		return false, nil
+	}
+
+	tail := make([]byte, len(ix.Tail)+1)
+	n, err := file.ReadAt(tail, ix.Size-int64(len(ix.Tail)))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	if !bytes.Equal(tail[:len(ix.Tail)], ix.Tail) {
+		return false, nil
+	}
+
+	// the last indexed line had no newline, so appended data must start a new line
+	appended := n > len(ix.Tail)
+	return !(ix.lastLineOpen() && appended && tail[len(ix.Tail)] != '\n'), nil
+}
+
+func (ix *Index) lastLineOpen() bool {
+	return len(ix.Tail) > 0 && ix.Tail[len(ix.Tail)-1] != '\n'
+}
+
+// update indexes everything after ix.Size
+func (ix *Index) update() error {
+	file, err := os.Open(ix.Source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	start := ix.Size
+	if ix.lastLineOpen() {
+		start++ // skip the newline that separates old and appended records
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	firstLine := ix.Lines
+	diag, err := scanUsers(file, ParseOptions{Mode: ParseLenient}, func(idx int, offset int64, u *user.User) {
+		p := Posting{Line: firstLine + idx, Offset: start + offset}
+		ix.add("browsers", p, u.Browsers...)
+		ix.add("company", p, u.Company)
+		ix.add("country", p, u.Country)
+		ix.add("job", p, u.Job)
+	})
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	ix.Lines += diag.Lines
+	ix.Size = info.Size()
+
+	tailStart := ix.Size - tailSize
+	if tailStart < 0 {
+		tailStart = 0
+	}
+	ix.Tail = make([]byte, ix.Size-tailStart)
+	_, err = file.ReadAt(ix.Tail, tailStart)
+	return err
+}
+
+func (ix *Index) add(field string, p Posting, values ...string) {
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, token := range tokenize(value) {
+			if seen[token] {
+				continue
+			}
+			seen[token] = true
+			key := field + ":" + token
+			ix.Terms[key] = append(ix.Terms[key], p)
+		}
+	}
+}
+
+func (ix *Index) save(indexPath string) error {
+	tmp := indexPath + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(ix); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, indexPath)
+}
+
+// Lookup returns postings of the records whose field contains token, ordered by line
+func (ix *Index) Lookup(field, token string) []Posting {
+	return ix.Terms[field+":"+strings.ToLower(token)]
+}
+
+// Union merges posting lists keeping the line order
+func Union(lists ...[]Posting) []Posting {
+	seen := make(map[int]bool)
+	result := make([]Posting, 0)
+	for _, list := range lists {
+		for _, p := range list {
+			if !seen[p.Line] {
+				seen[p.Line] = true
+				result = append(result, p)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Line < result[j].Line
+	})
+	return result
+}
+
+// ReadUsers seeks to every posting and decodes the record stored there
+func (ix *Index) ReadUsers(postings []Posting, fn func(p Posting, u *user.User)) error {
+	file, err := os.Open(ix.Source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for _, p := range postings {
+		if _, err := file.Seek(p.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		reader.Reset(file)
+
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		u := user.User{}
+		if err := easyjson.Unmarshal(bytes.TrimSuffix(line, []byte("\n")), &u); err != nil {
+			return &LineError{Line: p.Line + 1, Offset: p.Offset, Err: err}
+		}
+		fn(p, &u)
+	}
+	return nil
+}
+
+// IndexedSearch produces the same output as FastSearch reading only the candidate records.
+// masking may be nil, then DefaultMasking is used.
+func IndexedSearch(out io.Writer, ix *Index, masking *Masking) error {
+	if masking == nil {
+		masking = DefaultMasking()
+	}
+
+	uniqBrowsers := make(map[string]bool)
+	builder := strings.Builder{}
+
+	candidates := Union(ix.Lookup("browsers", "android"), ix.Lookup("browsers", "msie"))
+	err := ix.ReadUsers(candidates, func(p Posting, user *user.User) {
+		hasAndroid := false
+		hasIe := false
+
+		for _, browser := range user.Browsers {
+			android := strings.Contains(browser, "Android")
+			ie := strings.Contains(browser, "MSIE")
+			if android || ie {
+				uniqBrowsers[browser] = true
+			}
+			hasAndroid = hasAndroid || android
+			hasIe = hasIe || ie
+		}
+
+		if hasAndroid && hasIe {
+			writeFound(&builder, p.Line, user, masking)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "found users:\n"+builder.String())
+	fmt.Fprintln(out, "Total unique browsers", len(uniqBrowsers))
+	return nil
+}
+
+func tokenize(value string) []string {
+	return strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"user/user"
+)
+
+func TestIndexedSearch(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "users.txt")
+	indexPath := filepath.Join(dir, "users.idx")
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(source, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := OpenIndex(source, indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fastOut := new(bytes.Buffer)
+	FastSearch(fastOut)
+
+	indexedOut := new(bytes.Buffer)
+	if err := IndexedSearch(indexedOut, ix, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fastOut.String() != indexedOut.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", indexedOut, fastOut)
+	}
+
+	file, err := os.OpenFile(source, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("\n" + `{"browsers":["Qwerty/1.0"],"company":"Appended Inc","name":"New User"}` + "\n")
+	file.Close()
+
+	lines := ix.Lines
+	ix, err = OpenIndex(source, indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Lines != lines+1 {
+		t.Fatalf("expected %d lines after append, got %d", lines+1, ix.Lines)
+	}
+
+	postings := ix.Lookup("browsers", "Qwerty")
+	if len(postings) != 1 || postings[0].Line != lines {
+		t.Fatalf("appended record not indexed: %+v", postings)
+	}
+	err = ix.ReadUsers(postings, func(p Posting, u *user.User) {
+		if u.Name != "New User" {
+			t.Errorf("wrong record at %+v: %+v", p, u)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(source, []byte(`{"company":"Rewritten"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ix, err = OpenIndex(source, indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Lines != 1 || len(ix.Lookup("company", "rewritten")) != 1 {
+		t.Errorf("index was not rebuilt after rewrite: %d lines", ix.Lines)
+	}
+}
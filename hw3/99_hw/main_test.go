@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+	"user/user"
 )
 
 // запускаем перед основными функциями по разу чтобы файл остался в памяти в файловом кеше
@@ -18,7 +22,9 @@ func init() {
 
 func TestSearch(t *testing.T) {
 	slowOut := new(bytes.Buffer)
-	SlowSearch(slowOut)
+	if err := SlowSearch(slowOut); err != nil {
+		t.Fatal(err)
+	}
 	slowResult := slowOut.String()
 
 	fastOut := new(bytes.Buffer)
@@ -44,3 +50,121 @@ func BenchmarkFast(b *testing.B) {
 		FastSearch(ioutil.Discard)
 	}
 }
+
+// -----
+// go test -v -run TestFastSearchMalformed
+
+func writeUsersFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFastSearchMalformed(t *testing.T) {
+	good := `{"browsers":["Android 4.0","MSIE 9.0"],"email":"a@b.c","name":"Good"}`
+	path := writeUsersFile(t, good, `{"browsers":[`, good, `not json`)
+
+	out := new(bytes.Buffer)
+	diag, err := FastSearchFile(out, path, SearchOptions{ParseOptions: ParseOptions{Mode: ParseLenient}})
+	if err != nil {
+		t.Fatalf("lenient mode returned error: %s", err)
+	}
+	if diag.Lines != 4 || len(diag.Errors) != 2 {
+		t.Fatalf("unexpected diagnostics %+v", diag)
+	}
+	if e := diag.Errors[0]; e.Line != 2 || e.Offset != int64(len(good)+1) {
+		t.Errorf("wrong position of first error: %+v", e)
+	}
+	expected := "found users:\n[0] Good <a [at] b.c>\n[2] Good <a [at] b.c>\n\nTotal unique browsers 2\n"
+	if out.String() != expected {
+		t.Errorf("wrong lenient output\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+
+	out.Reset()
+	_, err = FastSearchFile(out, path, SearchOptions{ParseOptions: ParseOptions{Mode: ParseStrict, MaxErrors: 2}})
+	if err != nil {
+		t.Errorf("strict mode within budget returned error: %s", err)
+	}
+
+	out.Reset()
+	_, err = FastSearchFile(out, path, SearchOptions{ParseOptions: ParseOptions{Mode: ParseStrict, MaxErrors: 1}})
+	budgetErr := &ErrorBudgetExceeded{}
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected ErrorBudgetExceeded, got %v", err)
+	}
+	lineErr := &LineError{}
+	if !errors.As(err, &lineErr) || lineErr.Line != 4 {
+		t.Errorf("expected error at line 4, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("strict mode wrote partial output: %q", out.String())
+	}
+}
+
+func TestSlowSearchMalformed(t *testing.T) {
+	good := `{"browsers":["Android 4.0","MSIE 9.0"],"email":"a@b.c","name":"Good"}`
+	path := writeUsersFile(t, good, `{"browsers":[`, good)
+
+	out := new(bytes.Buffer)
+	err := slowSearchFile(out, path)
+	lineErr := &LineError{}
+	if !errors.As(err, &lineErr) {
+		t.Fatalf("expected LineError, got %v", err)
+	}
+	if lineErr.Line != 2 || lineErr.Offset != int64(len(good)+1) {
+		t.Errorf("wrong position of error: %+v", lineErr)
+	}
+	if out.Len() != 0 {
+		t.Errorf("slow search wrote partial output: %q", out.String())
+	}
+}
+
+func TestFastSearchMasking(t *testing.T) {
+	path := writeUsersFile(t,
+		`{"browsers":["Android 4.0","MSIE 9.0"],"email":"john.doe@mail.ru","name":"John Doe","phone":"+7 (495) 123-45-67"}`)
+	cfgPath := filepath.Join(t.TempDir(), "mask.json")
+	cfg := `{
+		"email": [{"rule": "hash_local", "salt": "s"}, {"rule": "at"}],
+		"phone": [{"rule": "partial", "keep": 4}],
+		"name":  [{"rule": "initials"}]
+	}`
+	if err := ioutil.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	masking, err := LoadMaskConfig(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	if _, err := FastSearchFile(out, path, SearchOptions{Masking: masking}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "found users:\n[0] J. D. <" + hashLocal("john.doe", "s") + " [at] mail.ru>\n\nTotal unique browsers 2\n"
+	if out.String() != expected {
+		t.Errorf("wrong masked output\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+
+	// SlowSearch masks generic maps, it must agree with FastSearch
+	u := user.User{Name: "John Doe", Email: "john.doe@mail.ru"}
+	m := map[string]interface{}{"name": u.Name, "email": u.Email}
+	masking.Apply(&u)
+	masking.ApplyMap(m)
+	if m["name"] != u.Name || m["email"] != u.Email {
+		t.Errorf("map masking differs: %v vs %+v", m, u)
+	}
+
+	if phone := redactDigits("+7 (495) 123-45-67", 4); phone != "+* (***) ***-45-67" {
+		t.Errorf("wrong phone redaction: %s", phone)
+	}
+
+	if _, err := NewMasking(MaskConfig{"password": {{Rule: "at"}}}); err == nil {
+		t.Errorf("expected error for unknown field")
+	}
+	if _, err := NewMasking(MaskConfig{"email": {{Rule: "rot13"}}}); err == nil {
+		t.Errorf("expected error for unknown rule")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"user/user"
+)
+
+// Masker hides personal data in a single field value
+type Masker interface {
+	Mask(value string) string
+}
+
+type MaskFunc func(value string) string
+
+func (f MaskFunc) Mask(value string) string {
+	return f(value)
+}
+
+// MaskRule is a single rule as declared in the config file
+type MaskRule struct {
+	Rule string `json:"rule"`
+	Salt string `json:"salt,omitempty"` // hash_local
+	Keep int    `json:"keep,omitempty"` // partial: how many trailing characters stay visible
+}
+
+// MaskConfig maps user json field names (email, phone, name...) to the rules applied in order.
+//
+//	{"email": [{"rule": "hash_local", "salt": "s3cr3t"}, {"rule": "at"}],
+//	 "phone": [{"rule": "partial", "keep": 4}],
+//	 "name":  [{"rule": "initials"}]}
+type MaskConfig map[string][]MaskRule
+
+// Masking applies the configured maskers to user records before they are printed
+type Masking struct {
+	fields map[string][]Masker
+}
+
+// DefaultMasking reproduces the original FastSearch output: "@" becomes " [at] "
+func DefaultMasking() *Masking {
+	m, _ := NewMasking(MaskConfig{"email": {{Rule: "at"}}})
+	return m
+}
+
+func LoadMaskConfig(path string) (*Masking, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := MaskConfig{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("bad mask config %s: %s", path, err)
+	}
+	return NewMasking(cfg)
+}
+
+func NewMasking(cfg MaskConfig) (*Masking, error) {
+	m := &Masking{fields: make(map[string][]Masker)}
+	for field, rules := range cfg {
+		if fieldRef(&user.User{}, field) == nil {
+			return nil, fmt.Errorf("unknown field %q in mask config", field)
+		}
+		for _, rule := range rules {
+			masker, err := newMasker(rule)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", field, err)
+			}
+			m.fields[field] = append(m.fields[field], masker)
+		}
+	}
+	return m, nil
+}
+
+// Apply masks u in place
+func (m *Masking) Apply(u *user.User) {
+	for field, maskers := range m.fields {
+		value := fieldRef(u, field)
+		for _, masker := range maskers {
+			*value = masker.Mask(*value)
+		}
+	}
+}
+
+// ApplyMap masks a user decoded into a generic map, as SlowSearch does
+func (m *Masking) ApplyMap(u map[string]interface{}) {
+	for field, maskers := range m.fields {
+		value, ok := u[field].(string)
+		if !ok {
+			continue
+		}
+		for _, masker := range maskers {
+			value = masker.Mask(value)
+		}
+		u[field] = value
+	}
+}
+
+func fieldRef(u *user.User, field string) *string {
+	switch field {
+	case "company":
+		return &u.Company
+	case "country":
+		return &u.Country
+	case "email":
+		return &u.Email
+	case "job":
+		return &u.Job
+	case "name":
+		return &u.Name
+	case "phone":
+		return &u.Phone
+	}
+	return nil
+}
+
+func newMasker(rule MaskRule) (Masker, error) {
+	switch rule.Rule {
+	case "none":
+		return MaskFunc(func(value string) string { return value }), nil
+	case "at":
+		return MaskFunc(func(value string) string {
+			return strings.ReplaceAll(value, "@", " [at] ")
+		}), nil
+	case "hash_local":
+		return MaskFunc(func(value string) string {
+			return hashLocal(value, rule.Salt)
+		}), nil
+	case "partial":
+		if rule.Keep < 0 {
+			return nil, fmt.Errorf("partial: keep must be >= 0")
+		}
+		return MaskFunc(func(value string) string {
+			return redactDigits(value, rule.Keep)
+		}), nil
+	case "initials":
+		return MaskFunc(initials), nil
+	}
+	return nil, fmt.Errorf("unknown mask rule %q", rule.Rule)
+}
+
+// hashLocal replaces the local part of an email with a short salted hash keeping the domain
+func hashLocal(email, salt string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		at = len(email)
+	}
+	sum := sha256.Sum256([]byte(salt + email[:at]))
+	return hex.EncodeToString(sum[:6]) + email[at:]
+}
+
+// redactDigits replaces every digit except the last keep ones with '*', formatting stays
+func redactDigits(phone string, keep int) string {
+	digits := 0
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+
+	result := []rune(phone)
+	for i, r := range result {
+		if r < '0' || r > '9' {
+			continue
+		}
+		if digits > keep {
+			result[i] = '*'
+		}
+		digits--
+	}
+	return string(result)
+}
+
+func initials(name string) string {
+	parts := strings.Fields(name)
+	for i, part := range parts {
+		parts[i] = string([]rune(part)[:1]) + "."
+	}
+	return strings.Join(parts, " ")
+}
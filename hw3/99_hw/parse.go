@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"user/user"
+
+	"github.com/mailru/easyjson"
+)
+
+type ParseMode int
+
+const (
+	// ParseStrict stops on the first malformed line once MaxErrors is spent
+	ParseStrict ParseMode = iota
+	// ParseLenient skips every malformed line and only reports it
+	ParseLenient
+)
+
+type ParseOptions struct {
+	Mode ParseMode
+	// MaxErrors - how many malformed lines strict mode tolerates before giving up
+	MaxErrors int
+}
+
+// LineError describes a single record that could not be decoded
+type LineError struct {
+	Line   int   // 1-based line number
+	Offset int64 // byte offset of the line start
+	Err    error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d (offset %d): %s", e.Line, e.Offset, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorBudgetExceeded is returned in strict mode when there are more malformed lines than allowed
+type ErrorBudgetExceeded struct {
+	Budget      int
+	Diagnostics *Diagnostics
+}
+
+func (e *ErrorBudgetExceeded) Error() string {
+	last := e.Diagnostics.Errors[len(e.Diagnostics.Errors)-1]
+	return fmt.Sprintf("too many malformed lines (budget %d): %s", e.Budget, &last)
+}
+
+func (e *ErrorBudgetExceeded) Unwrap() error {
+	return &e.Diagnostics.Errors[len(e.Diagnostics.Errors)-1]
+}
+
+type Diagnostics struct {
+	Lines  int // lines read, malformed included
+	Errors []LineError
+}
+
+// WriteReport prints one line per skipped record
+func (d *Diagnostics) WriteReport(out io.Writer) {
+	fmt.Fprintf(out, "lines: %d, malformed: %d\n", d.Lines, len(d.Errors))
+	for i := range d.Errors {
+		fmt.Fprintln(out, &d.Errors[i])
+	}
+}
+
+// scanUsers decodes users line by line and calls fn for every valid record.
+// idx passed to fn is the 0-based line number, so skipped lines keep their numbering,
+// offset is the position of the line start in r.
+func scanUsers(r io.Reader, opts ParseOptions, fn func(idx int, offset int64, u *user.User)) (*Diagnostics, error) {
+	diag := &Diagnostics{}
+	reader := bufio.NewReaderSize(r, 64*1024)
+
+	var (
+		offset int64
+		long   []byte
+	)
+
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			long = append(long[:0], line...)
+			for err == bufio.ErrBufferFull {
+				line, err = reader.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if err != nil && err != io.EOF {
+			return diag, err
+		}
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+
+		idx := diag.Lines
+		diag.Lines++
+		lineOffset := offset
+		offset += int64(len(line))
+
+		if line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+		}
+
+		u := user.User{}
+		if parseErr := easyjson.Unmarshal(line, &u); parseErr != nil {
+			diag.Errors = append(diag.Errors, LineError{
+				Line:   idx + 1,
+				Offset: lineOffset,
+				Err:    parseErr,
+			})
+			if opts.Mode == ParseStrict && len(diag.Errors) > opts.MaxErrors {
+				return diag, &ErrorBudgetExceeded{Budget: opts.MaxErrors, Diagnostics: diag}
+			}
+		} else {
+			fn(idx, lineOffset, &u)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return diag, nil
+}
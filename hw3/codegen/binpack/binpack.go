@@ -0,0 +1,469 @@
+// Package binpack is the reflection counterpart of the code generated by ../gen:
+// Marshal and Unmarshal produce exactly the same bytes as the generated Pack and
+// Unpack, so types that can't be generated (other packages, anonymous structs)
+// still speak the same format.
+//
+// Field tags are the same as for the generator:
+//
+//	`cgen:"-"`                    skip the field
+//	`cgen:"be"`, `cgen:"le"`      byte order of the field and its length prefixes
+//	`cgen:"u8"` ... `cgen:"i64"`  wire width of integers
+//	`cgen:"varint"`               zigzag varint for signed, uvarint for unsigned
+//	`cgen:"max=64"`               limit for the length prefix
+//	`cgen:"since=2"`              field appeared in version 2
+//
+// The struct level options of the "// cgen: binpack be version=2" mark can't be
+// seen by reflection, so they go to the tag of a blank field:
+//
+//	type Session struct {
+//		_     struct{} `cgen:"be,version=2"`
+//		Token string
+//	}
+//
+// The encoding plan of every type is built on first use and cached.
+package binpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+var (
+	ErrShortBuffer    = errors.New("binpack: short buffer")
+	ErrLengthExceeded = errors.New("binpack: length exceeded")
+	ErrBadValue       = errors.New("binpack: bad value")
+	ErrVersion        = errors.New("binpack: unsupported version")
+)
+
+// Error tells which field failed and where it starts in the data,
+// it is the BinpackError of the generated code
+type Error struct {
+	Field  string
+	Offset int64
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("binpack: %s at offset %d: %s", e.Field, e.Offset, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Marshal packs a struct or a pointer to a struct
+func Marshal(v interface{}) ([]byte, error) {
+	e := &encoder{}
+	if err := e.marshal(v); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Unmarshal unpacks data into the struct v points to. As the generated Unpack it
+// leaves fields missing from older versions and empty containers untouched.
+func Unmarshal(data []byte, v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("binpack: Unmarshal(%T): not a pointer to a struct", v)
+	}
+	sp, err := planFor(val.Elem().Type())
+	if err != nil {
+		return err
+	}
+	d := &decoder{data: data}
+	return d.unpackStruct(sp, val.Elem())
+}
+
+type encoder struct {
+	buf     []byte
+	scratch [binary.MaxVarintLen64]byte
+	field   string
+}
+
+// marshal appends v to e.buf
+func (e *encoder) marshal(v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return fmt.Errorf("binpack: Marshal(nil %s)", val.Type())
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return fmt.Errorf("binpack: Marshal(%T): not a struct", v)
+	}
+	sp, err := planFor(val.Type())
+	if err != nil {
+		return err
+	}
+	return e.packStruct(sp, val)
+}
+
+func (e *encoder) fail(err error) error {
+	return &Error{Field: e.field, Offset: int64(len(e.buf)), Err: err}
+}
+
+func (e *encoder) putUint(order binary.ByteOrder, size int, x uint64) {
+	switch size {
+	case 1:
+		e.buf = append(e.buf, byte(x))
+		return
+	case 2:
+		order.PutUint16(e.scratch[:], uint16(x))
+	case 4:
+		order.PutUint32(e.scratch[:], uint32(x))
+	case 8:
+		order.PutUint64(e.scratch[:], x)
+	}
+	e.buf = append(e.buf, e.scratch[:size]...)
+}
+
+// fits reports whether x comes back unchanged after a trip through the wire width of c,
+// read the way the decoder does it
+func fits(c *codec, v reflect.Value, x uint64) bool {
+	back := truncate(truncate(x, 8*c.size, c.signed), v.Type().Bits(), c.kind == kindInt)
+	return back == x
+}
+
+// truncate keeps the low bits of x extending the sign if needed
+func truncate(x uint64, bits int, signed bool) uint64 {
+	if bits >= 64 {
+		return x
+	}
+	shift := uint(64 - bits)
+	if signed {
+		return uint64(int64(x<<shift) >> shift)
+	}
+	return x << shift >> shift
+}
+
+func (e *encoder) packStruct(sp *structPlan, v reflect.Value) error {
+	if sp.version > 0 {
+		e.putUint(sp.order, 2, uint64(sp.version))
+	}
+	for _, f := range sp.fields {
+		e.field = sp.name + "." + f.name
+		if err := e.pack(f.codec, v.Field(f.index), f.max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) pack(c *codec, v reflect.Value, max int) error {
+	switch c.kind {
+	case kindString, kindBytes, kindSlice, kindMap:
+		if max > 0 && v.Len() > max {
+			return e.fail(ErrLengthExceeded)
+		}
+	}
+
+	switch c.kind {
+	case kindInt, kindUint:
+		var x uint64
+		if c.kind == kindInt {
+			x = uint64(v.Int())
+		} else {
+			x = v.Uint()
+		}
+		switch {
+		case c.varint && c.kind == kindInt:
+			e.buf = append(e.buf, e.scratch[:binary.PutVarint(e.scratch[:], int64(x))]...)
+		case c.varint:
+			e.buf = append(e.buf, e.scratch[:binary.PutUvarint(e.scratch[:], x)]...)
+		default:
+			if !fits(c, v, x) {
+				return e.fail(ErrBadValue)
+			}
+			e.putUint(c.order, c.size, x)
+		}
+
+	case kindFloat:
+		if c.size == 4 {
+			e.putUint(c.order, 4, uint64(math.Float32bits(float32(v.Float()))))
+		} else {
+			e.putUint(c.order, 8, math.Float64bits(v.Float()))
+		}
+
+	case kindBool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+
+	case kindString:
+		e.putUint(c.order, 4, uint64(v.Len()))
+		e.buf = append(e.buf, v.String()...)
+
+	case kindBytes:
+		e.putUint(c.order, 4, uint64(v.Len()))
+		e.buf = append(e.buf, v.Bytes()...)
+
+	case kindSlice, kindArray:
+		if c.kind == kindSlice {
+			e.putUint(c.order, 4, uint64(v.Len()))
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.pack(c.elem, v.Index(i), 0); err != nil {
+				return err
+			}
+		}
+
+	case kindMap:
+		// keys are sorted so equal maps always produce equal bytes
+		e.putUint(c.order, 4, uint64(v.Len()))
+		keys := v.MapKeys()
+		sort.Slice(keys, func(a, b int) bool { return lessKey(keys[a], keys[b]) })
+		for _, k := range keys {
+			if err := e.pack(c.key, k, 0); err != nil {
+				return err
+			}
+			if err := e.pack(c.elem, v.MapIndex(k), 0); err != nil {
+				return err
+			}
+		}
+
+	case kindPtr:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0)
+			return nil
+		}
+		e.buf = append(e.buf, 1)
+		return e.pack(c.elem, v.Elem(), 0)
+
+	case kindStruct:
+		return e.packStruct(c.st, v)
+	}
+	return nil
+}
+
+func lessKey(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	}
+	return a.String() < b.String()
+}
+
+type decoder struct {
+	data  []byte
+	pos   int
+	field string
+	off   int
+}
+
+func (d *decoder) fail(err error) error {
+	return &Error{Field: d.field, Offset: int64(d.off), Err: err}
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if len(d.data)-d.pos < n {
+		return nil, d.fail(ErrShortBuffer)
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint(order binary.ByteOrder, size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(order.Uint16(b)), nil
+	case 4:
+		return uint64(order.Uint32(b)), nil
+	}
+	return order.Uint64(b), nil
+}
+
+func (d *decoder) unpackStruct(sp *structPlan, v reflect.Value) error {
+	version := sp.version
+	if sp.version > 0 {
+		d.field, d.off = sp.name+".version", d.pos
+		x, err := d.uint(sp.order, 2)
+		if err != nil {
+			return err
+		}
+		if x == 0 || x > uint64(sp.version) {
+			return d.fail(ErrVersion)
+		}
+		version = int(x)
+	}
+	for _, f := range sp.fields {
+		if f.since > version {
+			// payloads of older versions simply don't have the newer fields
+			continue
+		}
+		d.field, d.off = sp.name+"."+f.name, d.pos
+		if err := d.unpack(f.codec, v.Field(f.index), f.max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) unpack(c *codec, v reflect.Value, max int) error {
+	switch c.kind {
+	case kindInt, kindUint:
+		var x int64
+		switch {
+		case c.varint:
+			var n int
+			if c.kind == kindInt {
+				x, n = binary.Varint(d.data[d.pos:])
+			} else {
+				var u uint64
+				u, n = binary.Uvarint(d.data[d.pos:])
+				x = int64(u)
+			}
+			if n == 0 {
+				return d.fail(ErrShortBuffer)
+			}
+			if n < 0 {
+				return d.fail(ErrBadValue)
+			}
+			d.pos += n
+		default:
+			u, err := d.uint(c.order, c.size)
+			if err != nil {
+				return err
+			}
+			x = int64(u)
+			if c.signed {
+				shift := uint(64 - 8*c.size)
+				x = x << shift >> shift
+			}
+		}
+		// the wire may be wider than the field, the value must fit it
+		if c.kind == kindInt {
+			if v.OverflowInt(x) {
+				return d.fail(ErrBadValue)
+			}
+			v.SetInt(x)
+		} else {
+			if v.OverflowUint(uint64(x)) {
+				return d.fail(ErrBadValue)
+			}
+			v.SetUint(uint64(x))
+		}
+
+	case kindFloat:
+		u, err := d.uint(c.order, c.size)
+		if err != nil {
+			return err
+		}
+		if c.size == 4 {
+			v.SetFloat(float64(math.Float32frombits(uint32(u))))
+		} else {
+			v.SetFloat(math.Float64frombits(u))
+		}
+
+	case kindBool:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+
+	case kindString, kindBytes:
+		n, err := d.unpackLen(c, max)
+		if err != nil {
+			return err
+		}
+		b, _ := d.next(n)
+		if c.kind == kindString {
+			v.SetString(string(b))
+		} else if n > 0 {
+			v.SetBytes(append([]byte(nil), b...))
+		}
+
+	case kindSlice:
+		n, err := d.unpackLen(c, max)
+		if err != nil || n == 0 {
+			return err
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		for i := 0; i < n; i++ {
+			if err := d.unpack(c.elem, v.Index(i), 0); err != nil {
+				return err
+			}
+		}
+
+	case kindArray:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.unpack(c.elem, v.Index(i), 0); err != nil {
+				return err
+			}
+		}
+
+	case kindMap:
+		n, err := d.unpackLen(c, max)
+		if err != nil || n == 0 {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := d.unpack(c.key, k, 0); err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.unpack(c.elem, elem, 0); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, elem)
+		}
+		v.Set(m)
+
+	case kindPtr:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		switch b[0] {
+		case 0:
+		case 1:
+			v.Set(reflect.New(v.Type().Elem()))
+			return d.unpack(c.elem, v.Elem(), 0)
+		default:
+			return d.fail(ErrBadValue)
+		}
+
+	case kindStruct:
+		return d.unpackStruct(c.st, v)
+	}
+	return nil
+}
+
+// unpackLen reads a length prefix and checks it before anything is allocated:
+// against max and against the bytes left in the input
+func (d *decoder) unpackLen(c *codec, max int) (int, error) {
+	x, err := d.uint(c.order, 4)
+	if err != nil {
+		return 0, err
+	}
+	if max > 0 && x > uint64(max) {
+		return 0, d.fail(ErrLengthExceeded)
+	}
+	if c.elemSize > 0 && x*uint64(c.elemSize) > uint64(len(d.data)-d.pos) {
+		return 0, d.fail(ErrShortBuffer)
+	}
+	return int(x), nil
+}
@@ -0,0 +1,256 @@
+package binpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// the same types as in ../pack, struct options moved to blank fields
+
+type User struct {
+	ID       int
+	RealName string `cgen:"-"`
+	Login    string `cgen:"max=64"`
+	Flags    int
+}
+
+type Role uint16
+
+type Header struct {
+	_      struct{} `cgen:"be"`
+	Magic  uint32
+	Length uint16 `cgen:"le"`
+	Seq    int64  `cgen:"varint"`
+	Code   int    `cgen:"u8"`
+	Hops   []uint `cgen:"varint,max=8"`
+}
+
+type Session struct {
+	_       struct{} `cgen:"version=2"`
+	ID      int      `cgen:"varint"`
+	Token   string
+	Expires uint64 `cgen:"since=2"`
+}
+
+// wire types wider than the fields
+type Small struct {
+	N  int8  `cgen:"i16"`
+	ID int16 `cgen:"varint"`
+}
+
+type SessionList struct {
+	Items []Session
+}
+
+type Profile struct {
+	Owner    User
+	Age      uint8
+	Rating   float64
+	Verified bool
+	Photo    []byte
+	Tags     []string `cgen:"max=16"`
+	Scores   [3]int16
+	Manager  *User
+	Settings map[string]int64
+	Role     Role
+	Friends  []*Profile
+}
+
+func TestUnmarshalPerl(t *testing.T) {
+	/*
+		perl -E '$b = pack("L L/a* L", 1_123_456, "v.romanov", 16);
+			print map { ord.", "  } split("", $b); '
+	*/
+	data := []byte{
+		128, 36, 17, 0,
+		9, 0, 0, 0,
+		118, 46, 114, 111, 109, 97, 110, 111, 118,
+		16, 0, 0, 0,
+	}
+
+	u := &User{}
+	if err := Unmarshal(data, u); err != nil {
+		t.Fatal(err)
+	}
+	expected := &User{ID: 1123456, Login: "v.romanov", Flags: 16}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("wrong user\nGot:\n%#v\nExpected:\n%#v", u, expected)
+	}
+
+	packed, err := Marshal(u)
+	if err != nil || !bytes.Equal(packed, data) {
+		t.Errorf("wrong Marshal: %v %v", packed, err)
+	}
+}
+
+// TestGeneratedBytes compares with the output of the generated Pack for the same values
+func TestGeneratedBytes(t *testing.T) {
+	cases := []struct {
+		In        interface{}
+		Generated string
+	}{
+		{
+			&Profile{
+				Owner:    User{ID: 9, Login: "owner", Flags: 3},
+				Age:      42,
+				Rating:   4.5,
+				Verified: true,
+				Photo:    []byte{1, 2, 3},
+				Tags:     []string{"go", "bin"},
+				Scores:   [3]int16{-2, 0, 300},
+				Manager:  &User{ID: 7, Login: "boss"},
+				Settings: map[string]int64{"b": 2, "a": -1},
+				Role:     5,
+				Friends:  []*Profile{{Age: 1}, nil},
+			},
+			"09000000050000006f776e6572030000002a000000000000124001030000000102030200000002000000676f03000000" +
+				"62696efeff00002c01010700000004000000626f737300000000020000000100000061ffffffffffffffff010000006202" +
+				"0000000000000005000200000001000000000000000000000000010000000000000000000000000000000000000000" +
+				"000000000000000000000000000000",
+		},
+		{
+			&Header{Magic: 0xCAFE, Length: 0x0102, Seq: -300, Code: 200, Hops: []uint{1, 300, 70000}},
+			"0000cafe0201d704c80000000301ac02f0a204",
+		},
+		{
+			&Session{ID: -5, Token: "tok", Expires: 1700000000},
+			"02000903000000746f6b00f1536500000000",
+		},
+	}
+
+	for caseNum, item := range cases {
+		data, err := Marshal(item.In)
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %v", caseNum, err)
+			continue
+		}
+		if hex.EncodeToString(data) != item.Generated {
+			t.Errorf("[%d] wrong bytes\nGot:\n%x\nExpected:\n%s", caseNum, data, item.Generated)
+		}
+
+		out := reflect.New(reflect.TypeOf(item.In).Elem()).Interface()
+		if err := Unmarshal(data, out); err != nil {
+			t.Errorf("[%d] Unmarshal: %v", caseNum, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, item.In) {
+			t.Errorf("[%d] round trip\nGot:\n%#v\nExpected:\n%#v", caseNum, out, item.In)
+		}
+	}
+}
+
+// TestUnmarshalOldVersions decodes the version 1 records the generated Unpack accepts
+func TestUnmarshalOldVersions(t *testing.T) {
+	// the bytes of TestSessionListOldVersions in ../pack
+	data := []byte{
+		2, 0, 0, 0,
+		1, 0, 2, 0, 0, 0, 0,
+		1, 0, 4, 0, 0, 0, 0,
+	}
+	out := &SessionList{}
+	if err := Unmarshal(data, out); err != nil {
+		t.Fatalf("old records in a slice: %v", err)
+	}
+	expected := &SessionList{Items: []Session{{ID: 1}, {ID: 2}}}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("wrong decode\nGot:\n%#v\nExpected:\n%#v", out, expected)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	valid := []byte{
+		128, 36, 17, 0,
+		9, 0, 0, 0,
+		118, 46, 114, 111, 109, 97, 110, 111, 118,
+		16, 0, 0, 0,
+	}
+
+	cases := []struct {
+		Data   []byte
+		Into   interface{}
+		Err    error
+		Field  string
+		Offset int64
+	}{
+		{valid[:2], &User{}, ErrShortBuffer, "User.ID", 0},
+		{valid[:10], &User{}, ErrShortBuffer, "User.Login", 4},
+		{valid[:19], &User{}, ErrShortBuffer, "User.Flags", 17},
+		// 4Gb login must fail before anything is allocated
+		{[]byte{0, 0, 0, 0, 255, 255, 255, 255}, &User{}, ErrLengthExceeded, "User.Login", 4},
+		{[]byte{0, 0, 0, 0, 60, 0, 0, 0}, &User{}, ErrShortBuffer, "User.Login", 4},
+		{[]byte{3, 0}, &Session{}, ErrVersion, "Session.version", 0},
+		{[]byte{1, 0, 0x80}, &Session{}, ErrShortBuffer, "Session.ID", 2},
+		{[]byte{0x2c, 0x01}, &Small{}, ErrBadValue, "Small.N", 0},
+		{[]byte{0, 0, 0xe0, 0xc5, 0x08}, &Small{}, ErrBadValue, "Small.ID", 2},
+	}
+
+	for caseNum, item := range cases {
+		err := Unmarshal(item.Data, item.Into)
+		binpackErr := &Error{}
+		if !errors.Is(err, item.Err) || !errors.As(err, &binpackErr) {
+			t.Errorf("[%d] expected %v, got %v", caseNum, item.Err, err)
+			continue
+		}
+		if binpackErr.Field != item.Field || binpackErr.Offset != item.Offset {
+			t.Errorf("[%d] wrong position: %s at %d", caseNum, binpackErr.Field, binpackErr.Offset)
+		}
+	}
+
+	u := &User{Login: string(bytes.Repeat([]byte("a"), 65))}
+	if _, err := Marshal(u); !errors.Is(err, ErrLengthExceeded) {
+		t.Errorf("expected ErrLengthExceeded from Marshal, got %v", err)
+	}
+
+	// Code is u8 on the wire, 300 would come back as 44
+	binpackErr := &Error{}
+	if _, err := Marshal(&Header{Code: 300}); !errors.Is(err, ErrBadValue) || !errors.As(err, &binpackErr) || binpackErr.Field != "Header.Code" {
+		t.Errorf("expected ErrBadValue for Header.Code from Marshal, got %v", err)
+	}
+	if _, err := Marshal(&Header{Code: 255}); err != nil {
+		t.Errorf("255 fits u8: %v", err)
+	}
+}
+
+func TestUnsupportedTypes(t *testing.T) {
+	cases := []interface{}{
+		&struct{ C chan int }{},
+		&struct{ M map[[2]int]int }{},
+		&struct {
+			F float64 `cgen:"varint"`
+		}{},
+		&struct {
+			N int `cgen:"u8,varint"`
+		}{},
+		&struct{ hidden int }{},
+		&struct {
+			_ struct{} `cgen:"version=1"`
+			N int      `cgen:"since=2"`
+		}{},
+	}
+	for caseNum, item := range cases {
+		if _, err := Marshal(item); err == nil {
+			t.Errorf("[%d] expected an error for %T", caseNum, item)
+		}
+		if err := Unmarshal(nil, item); err == nil {
+			t.Errorf("[%d] expected an Unmarshal error for %T", caseNum, item)
+		}
+	}
+
+	if err := Unmarshal(nil, User{}); err == nil {
+		t.Errorf("expected an error for a non-pointer")
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	p := &Profile{
+		Owner:    User{ID: 1, Login: "owner"},
+		Tags:     []string{"go", "bin"},
+		Settings: map[string]int64{"a": 1},
+	}
+	for i := 0; i < b.N; i++ {
+		Marshal(p)
+	}
+}
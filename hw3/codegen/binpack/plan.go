@@ -0,0 +1,389 @@
+package binpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type kind int
+
+const (
+	kindInt kind = iota
+	kindUint
+	kindFloat
+	kindBool
+	kindString
+	kindBytes
+	kindSlice
+	kindArray
+	kindMap
+	kindPtr
+	kindStruct
+)
+
+// codec describes how a Go type is laid out on the wire, it mirrors typeInfo of the generator
+type codec struct {
+	kind   kind
+	typ    reflect.Type
+	order  binary.ByteOrder // numbers and length prefixes
+	size   int              // fixed width numbers: bytes on the wire
+	signed bool             // fixed width integers: the wire type is signed
+	varint bool
+	len    int // arrays
+	elem   *codec
+	key    *codec
+	st     *structPlan
+
+	elemSize int // containers: the smallest encoded item, to check length prefixes
+}
+
+type fieldPlan struct {
+	name  string
+	index int
+	codec *codec
+	max   int
+	since int
+}
+
+type structPlan struct {
+	name    string
+	order   binary.ByteOrder
+	version int
+	fields  []fieldPlan
+	minSize int
+}
+
+// plans caches *structPlan by reflect.Type
+var plans sync.Map
+
+func planFor(t reflect.Type) (*structPlan, error) {
+	if sp, ok := plans.Load(t); ok {
+		return sp.(*structPlan), nil
+	}
+	b := &planBuilder{building: make(map[reflect.Type]*structPlan)}
+	sp, err := b.structPlan(t)
+	if err != nil {
+		return nil, err
+	}
+	b.setElemSizes()
+	for typ, built := range b.building {
+		plans.LoadOrStore(typ, built)
+	}
+	cached, _ := plans.Load(t)
+	if cached == nil {
+		return sp, nil
+	}
+	return cached.(*structPlan), nil
+}
+
+// planBuilder keeps the plans of one planFor call, recursive types point to
+// the plan that is still being built
+type planBuilder struct {
+	building   map[reflect.Type]*structPlan
+	containers []*codec
+}
+
+// setElemSizes runs last: sizes of containers are known only when every struct
+// they can hold is complete
+func (b *planBuilder) setElemSizes() {
+	for _, c := range b.containers {
+		switch c.kind {
+		case kindString, kindBytes:
+			c.elemSize = 1
+		case kindMap:
+			c.elemSize = minSize(c.key) + minSize(c.elem)
+		default:
+			c.elemSize = minSize(c.elem)
+		}
+	}
+}
+
+func (b *planBuilder) structPlan(t reflect.Type) (*structPlan, error) {
+	if sp, ok := plans.Load(t); ok {
+		return sp.(*structPlan), nil
+	}
+	if sp, ok := b.building[t]; ok {
+		return sp, nil
+	}
+
+	name := t.Name()
+	if name == "" {
+		name = t.String()
+	}
+	sp := &structPlan{name: name, order: binary.LittleEndian}
+	b.building[t] = sp
+
+	// struct options go first: they set the default order of every field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name != "_" {
+			continue
+		}
+		tag, ok := f.Tag.Lookup("cgen")
+		if !ok {
+			continue
+		}
+		if err := parseStructOptions(sp, tag); err != nil {
+			return nil, fmt.Errorf("binpack: struct %s: %s", name, err)
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("cgen")
+		if f.Name == "_" || tag == "-" {
+			continue
+		}
+		fail := func(err error) (*structPlan, error) {
+			return nil, fmt.Errorf("binpack: field %s.%s: %s", name, f.Name, err)
+		}
+		if f.Anonymous {
+			return fail(fmt.Errorf("embedded fields are not supported"))
+		}
+		if f.PkgPath != "" {
+			return fail(fmt.Errorf("unexported field, skip it with `cgen:\"-\"`"))
+		}
+
+		c, err := b.codec(f.Type)
+		if err != nil {
+			return fail(err)
+		}
+		opts, err := parseFieldOptions(tag)
+		if err == nil {
+			err = applyOptions(c, opts, sp.order)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if sp.version > 0 && opts.since > sp.version {
+			return fail(fmt.Errorf("since=%d is newer than version=%d", opts.since, sp.version))
+		}
+		if opts.since > sp.version {
+			sp.version = opts.since
+		}
+		sp.fields = append(sp.fields, fieldPlan{name: f.Name, index: i, codec: c, max: opts.max, since: opts.since})
+	}
+
+	// the oldest version: the header and the fields every version has
+	if sp.version > 0 {
+		sp.minSize = 2
+	}
+	for _, f := range sp.fields {
+		if f.since <= 1 {
+			sp.minSize += minSize(f.codec)
+		}
+	}
+	return sp, nil
+}
+
+// fixed width wire sizes of the builtin kinds, int and uint are written as 4 bytes
+var wireSizes = map[reflect.Kind]int{
+	reflect.Int: 4, reflect.Int8: 1, reflect.Int16: 2, reflect.Int32: 4, reflect.Int64: 8,
+	reflect.Uint: 4, reflect.Uint8: 1, reflect.Uint16: 2, reflect.Uint32: 4, reflect.Uint64: 8,
+	reflect.Float32: 4, reflect.Float64: 8, reflect.Bool: 1,
+}
+
+var byteType = reflect.TypeOf(byte(0))
+
+// codec builds a fresh codec for t, options are applied to it afterwards
+func (b *planBuilder) codec(t reflect.Type) (*codec, error) {
+	c := &codec{typ: t, size: wireSizes[t.Kind()]}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c.kind = kindInt
+		c.signed = t.Kind() != reflect.Int
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		c.kind = kindUint
+	case reflect.Float32, reflect.Float64:
+		c.kind = kindFloat
+	case reflect.Bool:
+		c.kind = kindBool
+	case reflect.String:
+		c.kind = kindString
+		b.containers = append(b.containers, c)
+
+	case reflect.Slice:
+		if t.Elem() == byteType {
+			c.kind = kindBytes
+			b.containers = append(b.containers, c)
+			return c, nil
+		}
+		c.kind = kindSlice
+		b.containers = append(b.containers, c)
+		return b.withElem(c, t.Elem())
+
+	case reflect.Array:
+		c.kind, c.len = kindArray, t.Len()
+		return b.withElem(c, t.Elem())
+
+	case reflect.Map:
+		key, err := b.codec(t.Key())
+		if err != nil {
+			return nil, err
+		}
+		switch key.kind {
+		case kindInt, kindUint, kindFloat, kindBool, kindString:
+		default:
+			return nil, fmt.Errorf("unsupported map key %s", t.Key())
+		}
+		c.kind, c.key = kindMap, key
+		b.containers = append(b.containers, c)
+		return b.withElem(c, t.Elem())
+
+	case reflect.Ptr:
+		c.kind = kindPtr
+		return b.withElem(c, t.Elem())
+
+	case reflect.Struct:
+		sp, err := b.structPlan(t)
+		if err != nil {
+			return nil, err
+		}
+		c.kind, c.st = kindStruct, sp
+
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+	return c, nil
+}
+
+func (b *planBuilder) withElem(c *codec, t reflect.Type) (*codec, error) {
+	elem, err := b.codec(t)
+	if err != nil {
+		return nil, err
+	}
+	c.elem = elem
+	return c, nil
+}
+
+// minSize is the smallest possible encoded size of c
+func minSize(c *codec) int {
+	switch c.kind {
+	case kindInt, kindUint, kindFloat, kindBool:
+		if c.varint {
+			return 1
+		}
+		return c.size
+	case kindString, kindBytes, kindSlice, kindMap:
+		return 4
+	case kindPtr:
+		return 1
+	case kindArray:
+		return c.len * minSize(c.elem)
+	case kindStruct:
+		return c.st.minSize
+	}
+	return 0
+}
+
+var byteOrders = map[string]binary.ByteOrder{
+	"le": binary.LittleEndian,
+	"be": binary.BigEndian,
+}
+
+type width struct {
+	size   int
+	signed bool
+}
+
+var widths = map[string]width{
+	"u8": {1, false}, "u16": {2, false}, "u32": {4, false}, "u64": {8, false},
+	"i8": {1, true}, "i16": {2, true}, "i32": {4, true}, "i64": {8, true},
+}
+
+// parseStructOptions reads the tag of the blank field: `cgen:"be,version=2"`
+func parseStructOptions(sp *structPlan, tag string) error {
+	for _, opt := range strings.Split(tag, ",") {
+		switch {
+		case byteOrders[opt] != nil:
+			sp.order = byteOrders[opt]
+		case strings.HasPrefix(opt, "version="):
+			v, err := strconv.Atoi(strings.TrimPrefix(opt, "version="))
+			if err != nil || v <= 0 || v > 0xFFFF {
+				return fmt.Errorf("bad %s", opt)
+			}
+			sp.version = v
+		default:
+			return fmt.Errorf("unknown cgen option %q", opt)
+		}
+	}
+	return nil
+}
+
+// fieldOptions come from the tag: `cgen:"be,u16,max=64,since=2"`
+type fieldOptions struct {
+	max    int
+	since  int
+	order  binary.ByteOrder
+	width  *width
+	varint bool
+}
+
+func parseFieldOptions(tag string) (fieldOptions, error) {
+	opts := fieldOptions{}
+	if tag == "" {
+		return opts, nil
+	}
+	for _, opt := range strings.Split(tag, ",") {
+		var err error
+		switch {
+		case byteOrders[opt] != nil:
+			opts.order = byteOrders[opt]
+		case widths[opt].size > 0:
+			w := widths[opt]
+			opts.width = &w
+		case opt == "varint":
+			opts.varint = true
+		case strings.HasPrefix(opt, "max="):
+			opts.max, err = strconv.Atoi(strings.TrimPrefix(opt, "max="))
+			if err != nil || opts.max <= 0 {
+				return opts, fmt.Errorf("bad %s", opt)
+			}
+		case strings.HasPrefix(opt, "since="):
+			opts.since, err = strconv.Atoi(strings.TrimPrefix(opt, "since="))
+			if err != nil || opts.since <= 0 || opts.since > 0xFFFF {
+				return opts, fmt.Errorf("bad %s", opt)
+			}
+		default:
+			return opts, fmt.Errorf("unknown cgen option %q", opt)
+		}
+	}
+	if opts.varint && opts.width != nil {
+		return opts, fmt.Errorf("varint and a fixed width are mutually exclusive")
+	}
+	return opts, nil
+}
+
+// applyOptions sets the byte order of c and its length prefixes and overrides the
+// wire format of every integer inside it. Nested structs keep their own options.
+func applyOptions(c *codec, opts fieldOptions, structOrder binary.ByteOrder) error {
+	if c == nil || c.kind == kindStruct {
+		return nil
+	}
+
+	c.order = structOrder
+	if opts.order != nil {
+		c.order = opts.order
+	}
+
+	switch c.kind {
+	case kindInt, kindUint:
+		if opts.width != nil {
+			c.size, c.signed = opts.width.size, opts.width.signed
+		}
+		c.varint = opts.varint
+	case kindFloat, kindBool:
+		if opts.width != nil || opts.varint {
+			return fmt.Errorf("integer encoding options can't be used with %s", c.typ)
+		}
+	}
+
+	if err := applyOptions(c.key, opts, structOrder); err != nil {
+		return err
+	}
+	return applyOptions(c.elem, opts, structOrder)
+}
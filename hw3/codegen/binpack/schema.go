@@ -0,0 +1,341 @@
+package binpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Schema is the JSON written by the generator with -schema
+type Schema struct {
+	Package string         `json:"package"`
+	Structs []StructSchema `json:"structs"`
+
+	plans map[string]*structPlan
+}
+
+type StructSchema struct {
+	Name    string        `json:"name"`
+	Order   string        `json:"order"`
+	Version int           `json:"version,omitempty"`
+	Fields  []FieldSchema `json:"fields"`
+}
+
+type FieldSchema struct {
+	Name  string      `json:"name"`
+	Type  *TypeSchema `json:"type"`
+	Max   int         `json:"max,omitempty"`
+	Since int         `json:"since,omitempty"`
+}
+
+type TypeSchema struct {
+	Kind   string      `json:"kind"`
+	Go     string      `json:"go"`
+	Wire   string      `json:"wire,omitempty"`
+	Order  string      `json:"order,omitempty"`
+	Len    int         `json:"len,omitempty"`
+	Elem   *TypeSchema `json:"elem,omitempty"`
+	Key    *TypeSchema `json:"key,omitempty"`
+	Struct string      `json:"struct,omitempty"`
+}
+
+// ParseSchema reads and checks a schema, the result is ready for DecodeMap
+func ParseSchema(data []byte) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// maxArrayLen limits arrays of a schema, DecodeMap allocates them before reading
+// and elements of zero size take no input at all
+const maxArrayLen = 1 << 20
+
+var kinds = map[string]kind{
+	"int": kindInt, "uint": kindUint, "float": kindFloat, "bool": kindBool,
+	"string": kindString, "bytes": kindBytes, "slice": kindSlice, "array": kindArray,
+	"map": kindMap, "ptr": kindPtr, "struct": kindStruct,
+}
+
+// compile turns the schema into the same plans Marshal uses
+func (s *Schema) compile() error {
+	s.plans = make(map[string]*structPlan, len(s.Structs))
+	for _, st := range s.Structs {
+		s.plans[st.Name] = &structPlan{name: st.Name, version: st.Version}
+	}
+
+	b := &planBuilder{}
+	for _, st := range s.Structs {
+		sp := s.plans[st.Name]
+		sp.order = byteOrders[st.Order]
+		if sp.order == nil {
+			return fmt.Errorf("binpack: struct %s: bad order %q", st.Name, st.Order)
+		}
+		for i, f := range st.Fields {
+			c, err := b.schemaCodec(s.plans, f.Type)
+			if err != nil {
+				return fmt.Errorf("binpack: field %s.%s: %s", st.Name, f.Name, err)
+			}
+			sp.fields = append(sp.fields, fieldPlan{name: f.Name, index: i, codec: c, max: f.Max, since: f.Since})
+		}
+	}
+
+	for _, st := range s.Structs {
+		if err := structMinSize(s.plans[st.Name], map[*structPlan]bool{}); err != nil {
+			return err
+		}
+	}
+	b.setElemSizes()
+	return nil
+}
+
+func (b *planBuilder) schemaCodec(plans map[string]*structPlan, t *TypeSchema) (*codec, error) {
+	if t == nil {
+		return nil, fmt.Errorf("no type")
+	}
+	k, ok := kinds[t.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %q", t.Kind)
+	}
+	c := &codec{kind: k, order: byteOrders[t.Order], len: t.Len}
+
+	switch k {
+	case kindInt, kindUint, kindFloat, kindBool:
+		switch {
+		case t.Wire == "varint" || t.Wire == "uvarint":
+			c.varint = true
+		case t.Wire == "f32" || t.Wire == "f64":
+			c.size = 4
+			if t.Wire == "f64" {
+				c.size = 8
+			}
+		case t.Wire == "bool":
+			c.size = 1
+		case widths[t.Wire].size > 0:
+			c.size, c.signed = widths[t.Wire].size, widths[t.Wire].signed
+		default:
+			return nil, fmt.Errorf("unknown wire %q", t.Wire)
+		}
+	case kindString, kindBytes, kindSlice, kindMap:
+		b.containers = append(b.containers, c)
+	case kindArray:
+		if t.Len < 0 || t.Len > maxArrayLen {
+			return nil, fmt.Errorf("bad len %d", t.Len)
+		}
+	case kindStruct:
+		c.st = plans[t.Struct]
+		if c.st == nil {
+			return nil, fmt.Errorf("unknown struct %q", t.Struct)
+		}
+	}
+	if c.order == nil && k != kindArray && k != kindPtr && k != kindStruct {
+		return nil, fmt.Errorf("bad order %q", t.Order)
+	}
+
+	var err error
+	switch k {
+	case kindMap:
+		if c.key, err = b.schemaCodec(plans, t.Key); err != nil {
+			return nil, err
+		}
+		fallthrough
+	case kindSlice, kindArray, kindPtr:
+		if c.elem, err = b.schemaCodec(plans, t.Elem); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// structMinSize fills minSize of sp and of the structs it contains directly,
+// which must not contain sp again
+func structMinSize(sp *structPlan, visiting map[*structPlan]bool) error {
+	if sp.minSize > 0 || len(sp.fields) == 0 {
+		return nil
+	}
+	if visiting[sp] {
+		return fmt.Errorf("binpack: struct %s contains itself", sp.name)
+	}
+	visiting[sp] = true
+	defer delete(visiting, sp)
+
+	size := 0
+	var walk func(c *codec) error
+	walk = func(c *codec) error {
+		switch c.kind {
+		case kindStruct:
+			return structMinSize(c.st, visiting)
+		case kindArray:
+			return walk(c.elem)
+		}
+		return nil
+	}
+	for _, f := range sp.fields {
+		if err := walk(f.codec); err != nil {
+			return err
+		}
+		// the oldest version: the header and the fields every version has
+		if f.since <= 1 {
+			size += minSize(f.codec)
+		}
+	}
+	if sp.version > 0 {
+		size += 2
+	}
+	sp.minSize = size
+	return nil
+}
+
+// DecodeMap unpacks data laid out as the struct name of the schema. Structs become
+// map[string]interface{} keyed by field names, slices and arrays []interface{},
+// maps map[string]interface{} with keys formatted by strconv, nil pointers nil.
+// Numbers are int64, uint64 or float64, bytes are []byte. Fields newer than the
+// version of the payload are left out.
+func DecodeMap(s *Schema, name string, data []byte) (map[string]interface{}, error) {
+	sp := s.plans[name]
+	if sp == nil {
+		return nil, fmt.Errorf("binpack: no struct %q in the schema", name)
+	}
+	d := &decoder{data: data}
+	return d.unpackMap(sp)
+}
+
+func (d *decoder) unpackMap(sp *structPlan) (map[string]interface{}, error) {
+	version := sp.version
+	if sp.version > 0 {
+		d.field, d.off = sp.name+".version", d.pos
+		x, err := d.uint(sp.order, 2)
+		if err != nil {
+			return nil, err
+		}
+		if x == 0 || x > uint64(sp.version) {
+			return nil, d.fail(ErrVersion)
+		}
+		version = int(x)
+	}
+
+	result := make(map[string]interface{}, len(sp.fields))
+	for _, f := range sp.fields {
+		if f.since > version {
+			continue
+		}
+		d.field, d.off = sp.name+"."+f.name, d.pos
+		v, err := d.unpackDynamic(f.codec, f.max)
+		if err != nil {
+			return nil, err
+		}
+		result[f.name] = v
+	}
+	return result, nil
+}
+
+// unpackDynamic is unpack for values without a Go type: it reads into a temporary
+// value of the wire kind and converts it to the generic representation
+func (d *decoder) unpackDynamic(c *codec, max int) (interface{}, error) {
+	switch c.kind {
+	case kindInt:
+		var x int64
+		err := d.unpack(c, reflect.ValueOf(&x).Elem(), max)
+		return x, err
+
+	case kindUint:
+		var x uint64
+		err := d.unpack(c, reflect.ValueOf(&x).Elem(), max)
+		return x, err
+
+	case kindFloat:
+		var x float64
+		err := d.unpack(c, reflect.ValueOf(&x).Elem(), max)
+		return x, err
+
+	case kindBool:
+		var x bool
+		err := d.unpack(c, reflect.ValueOf(&x).Elem(), max)
+		return x, err
+
+	case kindString:
+		var x string
+		err := d.unpack(c, reflect.ValueOf(&x).Elem(), max)
+		return x, err
+
+	case kindBytes:
+		var x []byte
+		err := d.unpack(c, reflect.ValueOf(&x).Elem(), max)
+		return x, err
+
+	case kindSlice, kindArray:
+		n := c.len
+		if c.kind == kindSlice {
+			var err error
+			if n, err = d.unpackLen(c, max); err != nil || n == 0 {
+				return nil, err
+			}
+		} else if uint64(n)*uint64(minSize(c.elem)) > uint64(len(d.data)-d.pos) {
+			return nil, d.fail(ErrShortBuffer)
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := d.unpackDynamic(c.elem, 0)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+
+	case kindMap:
+		n, err := d.unpackLen(c, max)
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := d.unpackDynamic(c.key, 0)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.unpackDynamic(c.elem, 0)
+			if err != nil {
+				return nil, err
+			}
+			m[formatKey(k)] = v
+		}
+		return m, nil
+
+	case kindPtr:
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		switch b[0] {
+		case 0:
+			return nil, nil
+		case 1:
+			return d.unpackDynamic(c.elem, 0)
+		}
+		return nil, d.fail(ErrBadValue)
+
+	case kindStruct:
+		return d.unpackMap(c.st)
+	}
+	return nil, fmt.Errorf("binpack: unknown kind %d", c.kind)
+}
+
+func formatKey(k interface{}) string {
+	switch k := k.(type) {
+	case int64:
+		return strconv.FormatInt(k, 10)
+	case uint64:
+		return strconv.FormatUint(k, 10)
+	case float64:
+		return strconv.FormatFloat(k, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(k)
+	}
+	return k.(string)
+}
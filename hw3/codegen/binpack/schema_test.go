@@ -0,0 +1,150 @@
+package binpack
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// ../pack/schema.json is written by the generator for the same types as in binpack_test.go
+func loadPackSchema(t *testing.T) *Schema {
+	data, err := ioutil.ReadFile("../pack/schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ParseSchema(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDecodeMap(t *testing.T) {
+	s := loadPackSchema(t)
+
+	cases := []struct {
+		Name     string
+		Data     string
+		Expected map[string]interface{}
+	}{
+		{
+			"Header",
+			"0000cafe0201d704c80000000301ac02f0a204",
+			map[string]interface{}{
+				"Magic":  uint64(0xCAFE),
+				"Length": uint64(0x0102),
+				"Seq":    int64(-300),
+				"Code":   int64(200),
+				"Hops":   []interface{}{uint64(1), uint64(300), uint64(70000)},
+			},
+		},
+		{
+			"Session",
+			"02000903000000746f6b00f1536500000000",
+			map[string]interface{}{"ID": int64(-5), "Token": "tok", "Expires": uint64(1700000000)},
+		},
+		{
+			// version 1 payloads have no Expires
+			"Session",
+			"01005402000000" + hex.EncodeToString([]byte("ok")),
+			map[string]interface{}{"ID": int64(42), "Token": "ok"},
+		},
+		{
+			// a slice of old records is shorter than the newest version would be
+			"SessionList",
+			"02000000" + "01000200000000" + "01000400000000",
+			map[string]interface{}{"Items": []interface{}{
+				map[string]interface{}{"ID": int64(1), "Token": ""},
+				map[string]interface{}{"ID": int64(2), "Token": ""},
+			}},
+		},
+		{
+			"Profile",
+			"09000000050000006f776e6572030000002a000000000000124001030000000102030200000002000000676f03000000" +
+				"62696efeff00002c01010700000004000000626f737300000000020000000100000061ffffffffffffffff010000006202" +
+				"0000000000000005000200000001000000000000000000000000010000000000000000000000000000000000000000" +
+				"000000000000000000000000000000",
+			map[string]interface{}{
+				"Owner":    map[string]interface{}{"ID": int64(9), "Login": "owner", "Flags": int64(3)},
+				"Age":      uint64(42),
+				"Rating":   4.5,
+				"Verified": true,
+				"Photo":    []byte{1, 2, 3},
+				"Tags":     []interface{}{"go", "bin"},
+				"Scores":   []interface{}{int64(-2), int64(0), int64(300)},
+				"Manager":  map[string]interface{}{"ID": int64(7), "Login": "boss", "Flags": int64(0)},
+				"Settings": map[string]interface{}{"a": int64(-1), "b": int64(2)},
+				"Role":     uint64(5),
+				"Friends": []interface{}{
+					map[string]interface{}{
+						"Owner":    map[string]interface{}{"ID": int64(0), "Login": "", "Flags": int64(0)},
+						"Age":      uint64(1),
+						"Rating":   0.0,
+						"Verified": false,
+						"Photo":    []byte(nil),
+						"Tags":     nil,
+						"Scores":   []interface{}{int64(0), int64(0), int64(0)},
+						"Manager":  nil,
+						"Settings": nil,
+						"Role":     uint64(0),
+						"Friends":  nil,
+					},
+					nil,
+				},
+			},
+		},
+	}
+
+	for caseNum, item := range cases {
+		data, _ := hex.DecodeString(item.Data)
+		result, err := DecodeMap(s, item.Name, data)
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %v", caseNum, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, item.Expected) {
+			t.Errorf("[%d] wrong result\nGot:\n%#v\nExpected:\n%#v", caseNum, result, item.Expected)
+		}
+	}
+}
+
+func TestDecodeMapErrors(t *testing.T) {
+	s := loadPackSchema(t)
+
+	if _, err := DecodeMap(s, "Avatar", nil); err == nil {
+		t.Errorf("expected an error for a struct missing from the schema")
+	}
+
+	_, err := DecodeMap(s, "User", []byte{0, 0, 0, 0, 255, 255, 255, 255})
+	binpackErr := &Error{}
+	if !errors.Is(err, ErrLengthExceeded) || !errors.As(err, &binpackErr) || binpackErr.Field != "User.Login" {
+		t.Errorf("expected ErrLengthExceeded for User.Login, got %v", err)
+	}
+
+	// a long array must fail before it is allocated
+	long, err := ParseSchema([]byte(`{"structs": [{"name": "A", "order": "le", "fields": [{"name": "X",
+		"type": {"kind": "array", "len": 1048576, "elem": {"kind": "uint", "wire": "u64", "order": "le"}}}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeMap(long, "A", []byte{1, 2, 3}); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("expected ErrShortBuffer for an array longer than the data, got %v", err)
+	}
+
+	bad := []string{
+		`{"structs": [{"name": "A", "order": "xx"}]}`,
+		`{"structs": [{"name": "A", "order": "le", "fields": [{"name": "X", "type": {"kind": "chan"}}]}]}`,
+		`{"structs": [{"name": "A", "order": "le", "fields": [{"name": "X", "type": {"kind": "int", "wire": "u3", "order": "le"}}]}]}`,
+		`{"structs": [{"name": "A", "order": "le", "fields": [{"name": "X", "type": {"kind": "struct", "struct": "B"}}]}]}`,
+		`{"structs": [{"name": "A", "order": "le", "fields": [{"name": "X", "type": {"kind": "struct", "struct": "A"}}]}]}`,
+		`{"structs": [{"name": "A", "order": "le", "fields": [{"name": "X", "type": {"kind": "array", "len": -1, "elem": {"kind": "bool", "wire": "bool", "order": "le"}}}]}]}`,
+		`{"structs": [{"name": "A", "order": "le", "fields": [{"name": "X", "type": {"kind": "array", "len": 1048577, "elem": {"kind": "bool", "wire": "bool", "order": "le"}}}]}]}`,
+	}
+	for caseNum, schema := range bad {
+		if _, err := ParseSchema([]byte(schema)); err == nil {
+			t.Errorf("[%d] expected an error for %s", caseNum, schema)
+		}
+	}
+}
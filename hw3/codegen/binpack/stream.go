@@ -0,0 +1,123 @@
+package binpack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"io"
+)
+
+// DefaultMaxRecordSize limits records read by a Decoder unless SetMaxRecordSize is called
+const DefaultMaxRecordSize = 16 << 20
+
+// Encoder writes a sequence of records: uvarint payload length, then the payload.
+// Values implementing encoding.BinaryMarshaler (all generated types) are packed by
+// their own method, everything else by Marshal.
+type Encoder struct {
+	w     io.Writer
+	enc   encoder
+	frame []byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes v as one record with a single Write call
+func (e *Encoder) Encode(v interface{}) error {
+	var payload []byte
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		payload = data
+	} else {
+		e.enc.buf = e.enc.buf[:0]
+		if err := e.enc.marshal(v); err != nil {
+			return err
+		}
+		payload = e.enc.buf
+	}
+
+	var size [binary.MaxVarintLen64]byte
+	e.frame = append(e.frame[:0], size[:binary.PutUvarint(size[:], uint64(len(payload)))]...)
+	e.frame = append(e.frame, payload...)
+	_, err := e.w.Write(e.frame)
+	return err
+}
+
+// Decoder reads records written by Encoder. The payload buffer is reused between
+// records: Unmarshal copies everything it keeps and UnmarshalBinary must do the
+// same, as encoding.BinaryUnmarshaler requires.
+type Decoder struct {
+	r       *bufio.Reader
+	buf     bytes.Buffer
+	offset  int64
+	maxSize int
+	prefix  countingReader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	d := &Decoder{r: br, maxSize: DefaultMaxRecordSize}
+	d.prefix.d = d
+	return d
+}
+
+// SetMaxRecordSize sets the limit for the payload length, bigger records fail
+// with ErrLengthExceeded before anything is read
+func (d *Decoder) SetMaxRecordSize(n int) {
+	d.maxSize = n
+}
+
+// Decode reads the next record into v. It returns io.EOF when the stream ends
+// between records and io.ErrUnexpectedEOF when it ends inside one. Offsets of
+// errors inside the payload are counted from the start of the payload.
+func (d *Decoder) Decode(v interface{}) error {
+	start := d.offset
+	size, err := binary.ReadUvarint(&d.prefix)
+	if err == io.EOF && d.offset > start {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if size > uint64(d.maxSize) {
+		return &Error{Field: "record", Offset: start, Err: ErrLengthExceeded}
+	}
+
+	// the buffer grows as the payload arrives, a length prefix alone allocates nothing
+	d.buf.Reset()
+	n, err := io.CopyN(&d.buf, d.r, int64(size))
+	d.offset += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	payload := d.buf.Bytes()
+	if u, ok := v.(encoding.BinaryUnmarshaler); ok {
+		return u.UnmarshalBinary(payload)
+	}
+	return Unmarshal(payload, v)
+}
+
+// countingReader keeps Decoder.offset right while the length prefix is read byte by byte
+type countingReader struct {
+	d *Decoder
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.d.r.ReadByte()
+	if err == nil {
+		c.d.offset++
+	}
+	return b, err
+}
@@ -0,0 +1,147 @@
+package binpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// login implements the encoding interfaces the way generated types do
+type login struct {
+	Name string
+}
+
+func (l *login) MarshalBinary() ([]byte, error) {
+	return []byte("L" + l.Name), nil
+}
+
+func (l *login) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != 'L' {
+		return ErrBadValue
+	}
+	l.Name = string(data[1:])
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	users := []*User{
+		{ID: 1, Login: "v.romanov", Flags: 16},
+		{ID: 2},
+		{ID: 3, Login: string(bytes.Repeat([]byte("a"), 64))},
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for _, u := range users {
+		if err := enc.Encode(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Encode(&login{"custom"}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := Marshal(users[0])
+	if buf.Bytes()[0] != byte(len(first)) || !bytes.Equal(buf.Bytes()[1:1+len(first)], first) {
+		t.Errorf("wrong first record: %v", buf.Bytes()[:1+len(first)])
+	}
+
+	dec := NewDecoder(buf)
+	for i, expected := range users {
+		u := &User{}
+		if err := dec.Decode(u); err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		if !reflect.DeepEqual(u, expected) {
+			t.Errorf("[%d] got %#v, expected %#v", i, u, expected)
+		}
+	}
+	l := &login{}
+	if err := dec.Decode(l); err != nil || l.Name != "custom" {
+		t.Errorf("custom record: %#v %v", l, err)
+	}
+	if err := dec.Decode(&User{}); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	record := []byte{12, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	cases := []struct {
+		Data []byte
+		Err  error
+	}{
+		{record[:5], io.ErrUnexpectedEOF},
+		{[]byte{0x80}, io.ErrUnexpectedEOF},
+		{[]byte{0xFF, 0xFF, 0xFF, 0x0F}, ErrLengthExceeded},
+		// the record is shorter than User needs
+		{[]byte{2, 1, 0}, ErrShortBuffer},
+	}
+
+	for caseNum, item := range cases {
+		dec := NewDecoder(bytes.NewReader(append(append([]byte{}, record...), item.Data...)))
+		dec.SetMaxRecordSize(1024)
+		if err := dec.Decode(&User{}); err != nil {
+			t.Fatalf("[%d] valid record: %v", caseNum, err)
+		}
+		if err := dec.Decode(&User{}); !errors.Is(err, item.Err) {
+			t.Errorf("[%d] expected %v, got %v", caseNum, item.Err, err)
+		}
+	}
+}
+
+func TestStreamShortRecord(t *testing.T) {
+	// a length prefix just under the limit and a few bytes of payload
+	var prefix [binary.MaxVarintLen64]byte
+	data := prefix[:binary.PutUvarint(prefix[:], DefaultMaxRecordSize)]
+	data = append(data, 1, 2, 3)
+
+	dec := NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&User{}); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if dec.buf.Cap() > 64<<10 {
+		t.Errorf("buffer of %d bytes allocated for a 3 byte payload", dec.buf.Cap())
+	}
+}
+
+func TestDecoderReusesBuffer(t *testing.T) {
+	type Blob struct {
+		Data []byte
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.Encode(&Blob{[]byte("first")})
+	enc.Encode(&Blob{[]byte("other")})
+
+	dec := NewDecoder(buf)
+	first, second := &Blob{}, &Blob{}
+	dec.Decode(first)
+	dec.Decode(second)
+	if string(first.Data) != "first" || string(second.Data) != "other" {
+		t.Errorf("decoded bytes must not share the record buffer: %q %q", first.Data, second.Data)
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for i := 0; i < b.N; i++ {
+		enc.Encode(&Session{ID: i, Token: "token", Expires: 1700000000})
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	dec := NewDecoder(buf)
+	s := &Session{}
+	for i := 0; i < b.N; i++ {
+		if err := dec.Decode(s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
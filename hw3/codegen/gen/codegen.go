@@ -1,128 +1,543 @@
-// go build gen/* && ./codegen.exe pack/unpack.go  pack/marshaller.go
-// go run pack/*
+// binpack generates Pack/Unpack methods for structs marked with "// cgen: binpack"
+// in every file of a package and writes them to a single gofmt-ed file:
+//
+//	go run ./gen -output marshaller.go ./pack
+//	go run pack/*
+//
+// or from inside the package:
+//
+//	//go:generate go run ../gen -output marshaller.go .
+//
+// -schema schema.json also describes the layout of every struct in JSON for
+// decoders in other languages, binpack.DecodeMap is the reference one.
+//
+// The old form with a single source file and the output path still works:
+//
+//	go build gen/* && ./codegen.exe pack/unpack.go pack/marshaller.go
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"go/ast"
+	"go/format"
 	"go/parser"
 	"go/token"
-	"log"
+	"go/types"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"text/template"
 )
 
-type tpl struct {
-	FieldName string
+type structInfo struct {
+	Name    string
+	Fields  []fieldInfo
+	Order   string
+	Version int // 0 - no version header
+}
+
+type fieldInfo struct {
+	Name  string
+	Type  *typeInfo
+	Max   int // cgen:"max=N" - limit for the length prefix
+	Since int // cgen:"since=N" - present in payloads of version N and newer
+}
+
+type sampleField struct {
+	Name   string
+	Sample string
 }
 
 var (
-	intTpl = template.Must(template.New("intTpl").Parse(`
-	// {{.FieldName}}
-	var {{.FieldName}}Raw uint32
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}Raw)
-	in.{{.FieldName}} = int({{.FieldName}}Raw)
+	roundTripTpl = template.Must(template.New("roundTripTpl").Parse(`
+func Test{{.Name}}BinpackRoundTrip(t *testing.T) {
+	in := &{{.Name}}{ {{- range .Fields}}
+		{{.Name}}: {{.Sample}},{{end}}
+	}
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+
+	out := &{{.Name}}{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch\nGot:\n%#v\nExpected:\n%#v", out, in)
+	}
+}
 `))
 
-	strTpl = template.Must(template.New("strTpl").Parse(`
-	// {{.FieldName}}
-	var {{.FieldName}}LenRaw uint32
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}LenRaw)
-	{{.FieldName}}Raw := make([]byte, {{.FieldName}}LenRaw)
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}Raw)
-	in.{{.FieldName}} = string({{.FieldName}}Raw)
+	// the seed is a valid payload, the fuzzer mutates it: Unpack must never panic and
+	// everything it accepts must survive another round trip
+	fuzzTpl = template.Must(template.New("fuzzTpl").Parse(`
+func Fuzz{{.Name}}Unpack(f *testing.F) {
+	seed := &{{.Name}}{ {{- range .Fields}}
+		{{.Name}}: {{.Sample}},{{end}}
+	}
+	data, err := seed.Pack()
+	if err != nil {
+		f.Fatalf("Pack: %s", err)
+	}
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v := &{{.Name}}{}
+		if err := v.Unpack(data); err != nil {
+			binpackErr := &BinpackError{}
+			if !errors.As(err, &binpackErr) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+
+		packed, err := v.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked value: %s", err)
+		}
+		again := &{{.Name}}{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of repacked value: %s", err)
+		}
+		repacked, _ := again.Pack()
+		if !bytes.Equal(packed, repacked) {
+			t.Errorf("repack mismatch\nGot:\n%v\nExpected:\n%v", repacked, packed)
+		}
+	})
+}
 `))
 )
 
+const generatedHeader = "// Code generated by binpack codegen. DO NOT EDIT.\n\n"
+
+var (
+	output  = flag.String("output", "binpack_gen.go", "output file name, relative to the package directory")
+	tests   = flag.Bool("tests", true, "also generate round-trip and fuzz tests into <output>_test.go")
+	verbose = flag.Bool("v", false, "print what is processed and skipped")
+	schema  = flag.String("schema", "", "also write a JSON description of the wire layout, relative to the package directory")
+)
+
+func logf(format string, args ...interface{}) {
+	if *verbose {
+		fmt.Printf(format, args...)
+	}
+}
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: codegen [flags] [package dir | file.go [output.go]]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	src, out := ".", ""
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		src = flag.Arg(0)
+	case 2:
+		src, out = flag.Arg(0), flag.Arg(1)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(src, out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// diagnostics collects every problem found in the sources, one "file:line:col: msg" per line
+type diagnostics []string
+
+func (d diagnostics) Error() string {
+	return strings.Join(d, "\n")
+}
+
+func run(src, out string) error {
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, os.Args[1], nil, parser.ParseComments)
+
+	dir, names := src, []string{}
+	if strings.HasSuffix(src, ".go") {
+		dir, names = filepath.Dir(src), []string{src}
+	} else {
+		var err error
+		if names, err = filepath.Glob(filepath.Join(src, "*.go")); err != nil {
+			return err
+		}
+	}
+	if out == "" {
+		out = filepath.Join(dir, *output)
+	}
+
+	files := make([]*ast.File, 0, len(names))
+	for _, name := range names {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		if isGenerated(file) {
+			logf("SKIP %s is generated\n", name)
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no go files in %s", src)
+	}
+
+	structs, diags := collectStructs(fset, files)
+	if len(diags) > 0 {
+		return diags
+	}
+
+	byName := make(map[string]*structInfo)
+	for i := range structs {
+		byName[structs[i].Name] = &structs[i]
+	}
+
+	pkg := files[0].Name.Name
+	if err := writeFormatted(out, generateCode(pkg, structs, byName)); err != nil {
+		return err
+	}
+	if *schema != "" {
+		if err := writeSchema(filepath.Join(dir, *schema), pkg, structs); err != nil {
+			return err
+		}
+	}
+	if !*tests {
+		return nil
+	}
+	return writeFormatted(strings.TrimSuffix(out, ".go")+"_test.go", generateTests(pkg, structs, byName))
+}
+
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "// Code generated ") && strings.HasSuffix(comment.Text, " DO NOT EDIT.") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeFormatted(path string, code []byte) error {
+	formatted, err := format.Source(code)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("generated code for %s doesn't compile: %s", path, err)
 	}
+	return ioutil.WriteFile(path, formatted, 0644)
+}
 
-	out, _ := os.Create(os.Args[2])
+func generateCode(pkg string, structs []structInfo, byName map[string]*structInfo) []byte {
+	out := new(bytes.Buffer)
+	out.WriteString(generatedHeader)
 
-	fmt.Fprintln(out, `package `+node.Name.Name)
+	fmt.Fprintln(out, `package `+pkg)
 	fmt.Fprintln(out) // empty line
-	fmt.Fprintln(out, `import "encoding/binary"`)
-	fmt.Fprintln(out, `import "bytes"`)
+	fmt.Fprintln(out, `import (`)
+	fmt.Fprintln(out, `	"bytes"`)
+	fmt.Fprintln(out, `	"encoding/binary"`)
+	fmt.Fprintln(out, `	"errors"`)
+	fmt.Fprintln(out, `	"fmt"`)
+	fmt.Fprintln(out, `	"io"`)
+	if usesMaps(structs) {
+		fmt.Fprintln(out, `	"sort"`)
+	}
+	fmt.Fprintln(out, `)`)
+	io.WriteString(out, runtime)
 	fmt.Fprintln(out) // empty line
 
-	for _, f := range node.Decls {
+	e := &emitter{out: out, structs: byName}
+	for _, st := range structs {
+		logf("\tgenerating Unpack method for %s\n", st.Name)
+		writeUnpack(e, st)
+		logf("\tgenerating Pack method for %s\n", st.Name)
+		writePack(e, st)
+
+		e.line("func (in *%s) MarshalBinary() ([]byte, error) {", st.Name)
+		e.line("	return in.Pack()")
+		e.line("}")
+		e.line("")
+		e.line("func (in *%s) UnmarshalBinary(data []byte) error {", st.Name)
+		e.line("	return in.Unpack(data)")
+		e.line("}")
+		e.line("")
+	}
+	return out.Bytes()
+}
+
+func writeUnpack(e *emitter, st structInfo) {
+	e.line("func (in *%s) Unpack(data []byte) error {", st.Name)
+	e.line("	return in.unpackFrom(bytes.NewReader(data))")
+	e.line("}")
+	e.line("")
+	e.block("func (in *%s) unpackFrom(r *bytes.Reader) error {", st.Name)
+	e.off = "off"
+	needOffset := false
+	for _, field := range st.Fields {
+		needOffset = needOffset || field.Type.Kind != kindStruct
+	}
+	if needOffset || st.Version > 0 {
+		e.line("var off int64")
+	}
+	if st.Version > 0 {
+		// payloads of older versions simply don't have the newer fields
+		e.field = st.Name + ".version"
+		e.line("")
+		e.line("// version header")
+		e.line("var version uint16")
+		e.read(st.Order, "&version")
+		e.fail(fmt.Sprintf("version == 0 || version > %d", st.Version), "ErrVersion")
+	}
+	for _, field := range st.Fields {
+		e.field = st.Name + "." + field.Name
+		e.line("")
+		e.line("// %s", field.Name)
+		if field.Since > 1 {
+			e.block("if version >= %d {", field.Since)
+		}
+		if field.Type.Kind != kindStruct {
+			e.line("off = r.Size() - int64(r.Len())")
+		}
+		e.unpack("in."+field.Name, field.Type, field.Max)
+		if field.Since > 1 {
+			e.end("}")
+		}
+	}
+	e.line("return nil")
+	e.end("}") // end of unpackFrom func
+	e.line("")
+}
+
+func writePack(e *emitter, st structInfo) {
+	e.line("func (in *%s) Pack() ([]byte, error) {", st.Name)
+	e.line("	w := new(bytes.Buffer)")
+	e.line("	err := in.packTo(w)")
+	e.line("	return w.Bytes(), err")
+	e.line("}")
+	e.line("")
+	e.block("func (in *%s) packTo(w *bytes.Buffer) error {", st.Name)
+	e.off = "int64(w.Len())"
+	if st.Version > 0 {
+		e.line("")
+		e.line("// version header")
+		e.line("binary.Write(w, %s, uint16(%d))", st.Order, st.Version)
+	}
+	for _, field := range st.Fields {
+		e.field = st.Name + "." + field.Name
+		e.line("")
+		e.line("// %s", field.Name)
+		e.pack("in."+field.Name, field.Type, field.Max)
+	}
+	e.line("return nil")
+	e.end("}") // end of packTo func
+	e.line("")
+}
+
+func generateTests(pkg string, structs []structInfo, byName map[string]*structInfo) []byte {
+	out := new(bytes.Buffer)
+	out.WriteString(generatedHeader)
+
+	fmt.Fprintln(out, `package `+pkg)
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, `import (`)
+	fmt.Fprintln(out, `	"bytes"`)
+	fmt.Fprintln(out, `	"errors"`)
+	fmt.Fprintln(out, `	"reflect"`)
+	fmt.Fprintln(out, `	"testing"`)
+	fmt.Fprintln(out, `)`)
+	for _, st := range structs {
+		data := struct {
+			Name   string
+			Fields []sampleField
+		}{Name: st.Name}
+		for _, f := range st.Fields {
+			data.Fields = append(data.Fields, sampleField{f.Name, sample(f.Type, byName, 1)})
+		}
+		roundTripTpl.Execute(out, data)
+		fuzzTpl.Execute(out, data)
+	}
+	return out.Bytes()
+}
+
+func usesMaps(structs []structInfo) bool {
+	var walk func(t *typeInfo) bool
+	walk = func(t *typeInfo) bool {
+		if t == nil {
+			return false
+		}
+		return t.Kind == kindMap || walk(t.Elem) || walk(t.Key)
+	}
+	for _, st := range structs {
+		for _, f := range st.Fields {
+			if walk(f.Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectStructs finds structs marked with "// cgen: binpack" in all files of the package
+func collectStructs(fset *token.FileSet, files []*ast.File) ([]structInfo, diagnostics) {
+	diags := diagnostics{}
+	report := func(pos token.Pos, format string, args ...interface{}) {
+		diags = append(diags, fset.Position(pos).String()+": "+fmt.Sprintf(format, args...))
+	}
+
+	res := &resolver{
+		structs: make(map[string]bool),
+		decls:   make(map[string]ast.Expr),
+	}
+	type markedStruct struct {
+		spec *ast.TypeSpec
+		mark string
+	}
+	marked := make([]markedStruct, 0)
+
+	decls := make([]ast.Decl, 0)
+	for _, file := range files {
+		decls = append(decls, file.Decls...)
+	}
+
+	for _, f := range decls {
 		g, ok := f.(*ast.GenDecl)
 		if !ok {
-			fmt.Printf("SKIP %#T is not *ast.GenDecl\n", f)
+			logf("SKIP %T is not *ast.GenDecl\n", f)
 			continue
 		}
 	SPECS_LOOP:
 		for _, spec := range g.Specs {
 			currType, ok := spec.(*ast.TypeSpec)
 			if !ok {
-				fmt.Printf("SKIP %#T is not ast.TypeSpec\n", spec)
+				logf("SKIP %T is not ast.TypeSpec\n", spec)
 				continue
 			}
+			res.decls[currType.Name.Name] = currType.Type
 
 			currStruct, ok := currType.Type.(*ast.StructType)
 			if !ok {
-				fmt.Printf("SKIP %#T is not ast.StructType\n", currStruct)
+				logf("SKIP %T is not ast.StructType\n", currStruct)
 				continue
 			}
 
-			if g.Doc == nil {
-				fmt.Printf("SKIP struct %#v doesnt have comments\n", currType.Name.Name)
+			doc := currType.Doc
+			if doc == nil && len(g.Specs) == 1 {
+				doc = g.Doc
+			}
+			if doc == nil {
+				logf("SKIP struct %#v doesnt have comments\n", currType.Name.Name)
 				continue
 			}
 
-			needCodegen := false
-			for _, comment := range g.Doc.List {
-				needCodegen = needCodegen || strings.HasPrefix(comment.Text, "// cgen: binpack")
+			mark := ""
+			for _, comment := range doc.List {
+				if strings.HasPrefix(comment.Text, "// cgen: binpack") {
+					mark = comment.Text
+				}
 			}
-			if !needCodegen {
-				fmt.Printf("SKIP struct %#v doesnt have cgen mark\n", currType.Name.Name)
+			if mark == "" {
+				logf("SKIP struct %#v doesnt have cgen mark\n", currType.Name.Name)
 				continue SPECS_LOOP
 			}
 
-			fmt.Printf("process struct %s\n", currType.Name.Name)
-			fmt.Printf("\tgenerating Unpack method\n")
+			// struct options may also come from a blank field, as the binpack package reads them:
+			// _ struct{} `cgen:"be,version=2"`
+			for _, field := range currStruct.Fields.List {
+				if len(field.Names) == 1 && field.Names[0].Name == "_" && field.Tag != nil {
+					tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1]).Get("cgen")
+					mark += " " + strings.Replace(tag, ",", " ", -1)
+				}
+			}
 
-			fmt.Fprintln(out, "func (in *"+currType.Name.Name+") Unpack(data []byte) error {")
-			fmt.Fprintln(out, "	r := bytes.NewReader(data)")
+			res.structs[currType.Name.Name] = true
+			marked = append(marked, markedStruct{currType, mark})
+		}
+	}
 
-		FIELDS_LOOP:
-			for _, field := range currStruct.Fields.List {
+	structs := make([]structInfo, 0, len(marked))
+	for _, m := range marked {
+		currType := m.spec
+		logf("process struct %s\n", currType.Name.Name)
 
-				if field.Tag != nil {
-					tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
-					if tag.Get("cgen") == "-" {
-						continue FIELDS_LOOP
-					}
+		structOpts, err := parseStructOptions(m.mark)
+		if err != nil {
+			report(currType.Pos(), "struct %s: %s", currType.Name.Name, err)
+			continue
+		}
+		st := structInfo{Name: currType.Name.Name, Order: structOpts.Order, Version: structOpts.Version}
+
+	FIELDS_LOOP:
+		for _, field := range currType.Type.(*ast.StructType).Fields.List {
+
+			tag := ""
+			if field.Tag != nil {
+				tag = reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1]).Get("cgen")
+				if tag == "-" {
+					continue FIELDS_LOOP
 				}
+			}
 
-				fieldName := field.Names[0].Name
-				fileType := field.Type.(*ast.Ident).Name
+			if len(field.Names) == 1 && field.Names[0].Name == "_" {
+				continue FIELDS_LOOP
+			}
 
-				fmt.Printf("\tgenerating code for field %s.%s\n", currType.Name.Name, fieldName)
+			if len(field.Names) == 0 {
+				report(field.Pos(), "embedded field %s in %s is not supported", types.ExprString(field.Type), st.Name)
+				continue FIELDS_LOOP
+			}
 
-				switch fileType {
-				case "int":
-					intTpl.Execute(out, tpl{fieldName})
-				case "string":
-					strTpl.Execute(out, tpl{fieldName})
-				default:
-					log.Fatalln("unsupported", fileType)
-				}
+			t, err := res.resolve(field.Type)
+			if err != nil {
+				report(field.Type.Pos(), "field %s.%s: %s", st.Name, field.Names[0].Name, err)
+				continue FIELDS_LOOP
 			}
 
-			fmt.Fprintln(out, "	return nil")
-			fmt.Fprintln(out, "}") // end of Unpack func
-			fmt.Fprintln(out)      // empty line
+			opts, err := parseFieldOptions(tag)
+			if err == nil {
+				err = applyOptions(t, opts, st.Order)
+			}
+			if err != nil {
+				report(field.Tag.Pos(), "field %s.%s: %s", st.Name, field.Names[0].Name, err)
+				continue FIELDS_LOOP
+			}
+			if structOpts.Version > 0 && opts.Since > structOpts.Version {
+				report(field.Tag.Pos(), "field %s.%s: since=%d is newer than version=%d",
+					st.Name, field.Names[0].Name, opts.Since, structOpts.Version)
+				continue FIELDS_LOOP
+			}
+			if opts.Since > st.Version {
+				st.Version = opts.Since
+			}
 
+			for _, name := range field.Names {
+				logf("\tgenerating code for field %s.%s\n", st.Name, name.Name)
+				st.Fields = append(st.Fields, fieldInfo{Name: name.Name, Type: t, Max: opts.Max, Since: opts.Since})
+			}
 		}
+
+		structs = append(structs, st)
 	}
-}
 
-// go build gen/* && ./codegen.exe pack/unpack.go  pack/marshaller.go
-// go run pack/*
+	if len(structs) == 0 && len(diags) == 0 {
+		report(files[0].Package, "no structs marked with // cgen: binpack")
+	}
+	return structs, diags
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func collect(t *testing.T, src string) ([]structInfo, diagnostics) {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "src.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collectStructs(fset, []*ast.File{file})
+}
+
+func TestDiagnostics(t *testing.T) {
+	src := `package p
+
+// cgen: binpack
+type A struct {
+	Ch   chan int
+	N    float64 ` + "`cgen:\"u8\"`" + `
+	S    string  ` + "`cgen:\"max=0\"`" + `
+	Ok   int
+}
+
+// cgen: binpack fast
+type B struct{}
+
+// cgen: binpack version=1
+type C struct {
+	N int ` + "`cgen:\"since=2\"`" + `
+}
+`
+	structs, diags := collect(t, src)
+
+	// every problem is reported at once, not only the first one
+	expected := []string{
+		"src.go:5:7: field A.Ch: unsupported type",
+		"src.go:6:15: field A.N: integer encoding options can't be used with float64",
+		"src.go:7:15: field A.S: bad max=0",
+		"src.go:12:6: struct B: unknown cgen option \"fast\"",
+		"src.go:16:8: field C.N: since=2 is newer than version=1",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("wrong diagnostics\nGot:\n%s\nExpected:\n%s", diags, strings.Join(expected, "\n"))
+	}
+	for i, msg := range expected {
+		if !strings.HasPrefix(diags[i], msg) {
+			t.Errorf("[%d] wrong diagnostic\nGot:\n%s\nExpected:\n%s", i, diags[i], msg)
+		}
+	}
+
+	if len(structs) != 2 || len(structs[0].Fields) != 1 || structs[0].Fields[0].Name != "Ok" {
+		t.Errorf("valid fields must still be collected: %+v", structs)
+	}
+
+	if _, diags := collect(t, "package p\n\ntype A struct{}\n"); len(diags) != 1 || !strings.Contains(diags[0], "no structs marked") {
+		t.Errorf("expected a diagnostic for a package without marks, got %v", diags)
+	}
+}
+
+func TestNarrowWireCheck(t *testing.T) {
+	src := `package p
+
+// cgen: binpack
+type A struct {
+	Code  int    ` + "`cgen:\"u8\"`" + `
+	Small int8   ` + "`cgen:\"i64\"`" + `
+	Len   uint16
+	Seq   int64  ` + "`cgen:\"varint\"`" + `
+}
+`
+	structs, diags := collect(t, src)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	byName := map[string]*structInfo{"A": &structs[0]}
+	code := string(generateCode("p", structs, byName))
+
+	if !strings.Contains(code, "if int(uint8(in.Code)) != in.Code {") {
+		t.Errorf("no range check for a narrowed field:\n%s", code)
+	}
+	for _, field := range []string{"Small", "Len", "Seq"} {
+		if strings.Contains(code, "(in."+field+")) != in."+field) {
+			t.Errorf("unexpected range check for %s:\n%s", field, code)
+		}
+	}
+}
+
+func TestWideWireCheck(t *testing.T) {
+	src := `package p
+
+// cgen: binpack
+type A struct {
+	Small int8   ` + "`cgen:\"i64\"`" + `
+	ID    int32  ` + "`cgen:\"varint\"`" + `
+	Len   uint16
+	Seq   int64  ` + "`cgen:\"varint\"`" + `
+}
+`
+	structs, diags := collect(t, src)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	byName := map[string]*structInfo{"A": &structs[0]}
+	code := string(generateCode("p", structs, byName))
+
+	// a decoded value that doesn't fit the field is an error, not a truncated number
+	checks := map[string]string{
+		"Small": "int64(int8(",
+		"ID":    "int64(int32(",
+		"Len":   "uint16(uint16(",
+		"Seq":   "int64(int64(",
+	}
+	for field, check := range checks {
+		found := strings.Contains(code, check)
+		if expected := field == "Small" || field == "ID"; found != expected {
+			t.Errorf("range check for %s: got %v, expected %v:\n%s", field, found, expected, code)
+		}
+	}
+}
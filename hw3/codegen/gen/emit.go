@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// runtime is written once into every generated file
+const runtime = `
+var (
+	ErrShortBuffer    = errors.New("binpack: short buffer")
+	ErrLengthExceeded = errors.New("binpack: length exceeded")
+	ErrBadValue       = errors.New("binpack: bad value")
+	ErrVersion        = errors.New("binpack: unsupported version")
+)
+
+// BinpackError tells which field failed to unpack and where it starts in the input
+type BinpackError struct {
+	Field  string
+	Offset int64
+	Err    error
+}
+
+func (e *BinpackError) Error() string {
+	return fmt.Sprintf("binpack: %s at offset %d: %s", e.Field, e.Offset, e.Err)
+}
+
+func (e *BinpackError) Unwrap() error {
+	return e.Err
+}
+
+func binpackError(field string, off int64, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrShortBuffer
+	}
+	return &BinpackError{Field: field, Offset: off, Err: err}
+}
+
+func binpackPutVarint(w *bytes.Buffer, x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutVarint(buf[:], x)])
+}
+
+func binpackPutUvarint(w *bytes.Buffer, x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], x)])
+}
+`
+
+// emitter writes Go code keeping track of indentation and of unique variable names
+type emitter struct {
+	out     io.Writer
+	indent  int
+	vars    int
+	field   string // Struct.Field being generated, used in error messages
+	off     string // expression with the offset reported in errors
+	structs map[string]*structInfo
+}
+
+func (e *emitter) line(format string, args ...interface{}) {
+	if format == "" {
+		fmt.Fprintln(e.out)
+		return
+	}
+	fmt.Fprintf(e.out, strings.Repeat("\t", e.indent)+format+"\n", args...)
+}
+
+func (e *emitter) block(format string, args ...interface{}) {
+	e.line(format, args...)
+	e.indent++
+}
+
+func (e *emitter) end(closing string) {
+	e.indent--
+	e.line(closing)
+}
+
+func (e *emitter) newVar(prefix string) string {
+	e.vars++
+	return fmt.Sprintf("%s%d", prefix, e.vars)
+}
+
+// fail returns a BinpackError for the current field from the generated function
+func (e *emitter) fail(cond, err string) {
+	e.block("if %s {", cond)
+	e.line("return binpackError(%q, %s, %s)", e.field, e.off, err)
+	e.end("}")
+}
+
+func (e *emitter) read(order, dst string) {
+	e.block("if err := binary.Read(r, %s, %s); err != nil {", order, dst)
+	e.line("return binpackError(%q, %s, err)", e.field, e.off)
+	e.end("}")
+}
+
+// unpack reads a value of type t from r into the assignable expression target.
+// max limits the length prefix of strings and containers, 0 - no limit.
+func (e *emitter) unpack(target string, t *typeInfo, max int) {
+	switch t.Kind {
+	case kindInt, kindUint, kindFloat, kindBool:
+		v := e.newVar("v")
+		wire := t.Wire
+		if t.Varint {
+			fn := "ReadVarint"
+			wire = "int64"
+			if t.Kind == kindUint {
+				fn, wire = "ReadUvarint", "uint64"
+			}
+			e.line("%s, err := binary.%s(r)", v, fn)
+			e.fail("err != nil", "err")
+			// varints carry 64 bits, the field may hold less
+			if t.Size < 8 {
+				e.fail(fmt.Sprintf("%s(%s(%s)) != %s", wire, t.Go, v, v), "ErrBadValue")
+			}
+		} else {
+			e.line("var %s %s", v, t.Wire)
+			e.read(t.Order, "&"+v)
+			if wireSize[wire] > t.Size && t.Size > 0 {
+				e.fail(fmt.Sprintf("%s(%s(%s)) != %s", wire, t.Go, v, v), "ErrBadValue")
+			}
+		}
+		e.line("%s = %s(%s)", target, t.Go, v)
+
+	case kindString, kindBytes:
+		n := e.unpackLen(t, max)
+		v := e.newVar("v")
+		e.line("%s := make([]byte, %s)", v, n)
+		e.read(t.Order, v)
+		if t.Kind == kindBytes {
+			e.block("if %s > 0 {", n)
+			e.line("%s = %s(%s)", target, t.Go, v)
+			e.end("}")
+		} else {
+			e.line("%s = %s(%s)", target, t.Go, v)
+		}
+
+	case kindSlice:
+		n := e.unpackLen(t, max)
+		e.block("if %s > 0 {", n)
+		e.line("%s = make(%s, %s)", target, t.Go, n)
+		i := e.newVar("i")
+		e.block("for %s := range %s {", i, target)
+		e.unpack(target+"["+i+"]", t.Elem, 0)
+		e.end("}")
+		e.end("}")
+
+	case kindArray:
+		i := e.newVar("i")
+		e.block("for %s := range %s {", i, target)
+		e.unpack(target+"["+i+"]", t.Elem, 0)
+		e.end("}")
+
+	case kindMap:
+		n := e.unpackLen(t, max)
+		e.block("if %s > 0 {", n)
+		e.line("%s = make(%s, %s)", target, t.Go, n)
+		i := e.newVar("i")
+		e.block("for %s := uint32(0); %s < %s; %s++ {", i, i, n, i)
+		k, v := e.newVar("k"), e.newVar("v")
+		e.line("var %s %s", k, t.Key.Go)
+		e.unpack(k, t.Key, 0)
+		e.line("var %s %s", v, t.Elem.Go)
+		e.unpack(v, t.Elem, 0)
+		e.line("%s[%s] = %s", target, k, v)
+		e.end("}")
+		e.end("}")
+
+	case kindPtr:
+		present := e.newVar("present")
+		e.line("var %s uint8", present)
+		e.read("binary.LittleEndian", "&"+present)
+		e.fail(present+" > 1", "ErrBadValue")
+		e.block("if %s == 1 {", present)
+		e.line("%s = new(%s)", target, t.Elem.Go)
+		e.unpack("(*"+target+")", t.Elem, 0)
+		e.end("}")
+
+	case kindStruct:
+		e.block("if err := %s.unpackFrom(r); err != nil {", target)
+		e.line("return err")
+		e.end("}")
+	}
+}
+
+// unpackLen reads a length prefix and checks it before anything is allocated:
+// against max and against the bytes left in the input
+func (e *emitter) unpackLen(t *typeInfo, max int) string {
+	n := e.newVar("n")
+	e.line("var %s uint32", n)
+	e.read(t.Order, "&"+n)
+	if max > 0 {
+		e.fail(fmt.Sprintf("%s > %d", n, max), "ErrLengthExceeded")
+	}
+
+	elemSize := 1
+	switch t.Kind {
+	case kindSlice:
+		elemSize = e.minSize(t.Elem)
+	case kindMap:
+		elemSize = e.minSize(t.Key) + e.minSize(t.Elem)
+	}
+	if elemSize > 0 {
+		e.fail(fmt.Sprintf("uint64(%s)*%d > uint64(r.Len())", n, elemSize), "ErrShortBuffer")
+	}
+	return n
+}
+
+// minSize is the smallest possible encoded size of t
+func (e *emitter) minSize(t *typeInfo) int {
+	switch t.Kind {
+	case kindInt, kindUint, kindFloat, kindBool:
+		if t.Varint {
+			return 1
+		}
+		return wireSize[t.Wire]
+	case kindString, kindBytes, kindSlice, kindMap:
+		return 4
+	case kindPtr:
+		return 1
+	case kindArray:
+		return t.Len * e.minSize(t.Elem)
+	case kindStruct:
+		// the oldest version: the header and the fields every version has
+		st, size := e.structs[t.Go], 0
+		if st.Version > 0 {
+			size = 2
+		}
+		for _, f := range st.Fields {
+			if f.Since <= 1 {
+				size += e.minSize(f.Type)
+			}
+		}
+		return size
+	}
+	return 0
+}
+
+var wireSize = map[string]int{
+	"bool": 1, "int8": 1, "uint8": 1,
+	"int16": 2, "uint16": 2,
+	"int32": 4, "uint32": 4, "float32": 4,
+	"int64": 8, "uint64": 8, "float64": 8,
+}
+
+// pack writes expr of type t to w, max is checked the same way unpack does
+func (e *emitter) pack(expr string, t *typeInfo, max int) {
+	if max > 0 {
+		switch t.Kind {
+		case kindString, kindBytes, kindSlice, kindMap:
+			e.fail(fmt.Sprintf("len(%s) > %d", expr, max), "ErrLengthExceeded")
+		}
+	}
+
+	switch t.Kind {
+	case kindInt, kindUint, kindFloat, kindBool:
+		switch {
+		case t.Varint && t.Kind == kindInt:
+			e.line("binpackPutVarint(w, int64(%s))", expr)
+		case t.Varint:
+			e.line("binpackPutUvarint(w, uint64(%s))", expr)
+		default:
+			// a narrower wire type must give the value back unchanged, or the data is lost
+			if wireSize[t.Wire] < t.Size {
+				e.fail(fmt.Sprintf("%s(%s(%s)) != %s", t.Go, t.Wire, expr, expr), "ErrBadValue")
+			}
+			e.line("binary.Write(w, %s, %s(%s))", t.Order, t.Wire, expr)
+		}
+
+	case kindString:
+		e.line("binary.Write(w, %s, uint32(len(%s)))", t.Order, expr)
+		e.line("w.WriteString(string(%s))", expr)
+
+	case kindBytes:
+		e.line("binary.Write(w, %s, uint32(len(%s)))", t.Order, expr)
+		e.line("w.Write(%s)", expr)
+
+	case kindSlice:
+		e.line("binary.Write(w, %s, uint32(len(%s)))", t.Order, expr)
+		v := e.newVar("v")
+		e.block("for _, %s := range %s {", v, expr)
+		e.pack(v, t.Elem, 0)
+		e.end("}")
+
+	case kindArray:
+		v := e.newVar("v")
+		e.block("for _, %s := range %s {", v, expr)
+		e.pack(v, t.Elem, 0)
+		e.end("}")
+
+	case kindMap:
+		// keys are sorted so equal maps always produce equal bytes
+		e.line("binary.Write(w, %s, uint32(len(%s)))", t.Order, expr)
+		keys := e.newVar("keys")
+		e.line("%s := make([]%s, 0, len(%s))", keys, t.Key.Go, expr)
+		k := e.newVar("k")
+		e.block("for %s := range %s {", k, expr)
+		e.line("%s = append(%s, %s)", keys, keys, k)
+		e.end("}")
+		if t.Key.Kind == kindBool {
+			e.line("sort.Slice(%s, func(a, b int) bool { return !%s[a] && %s[b] })", keys, keys, keys)
+		} else {
+			e.line("sort.Slice(%s, func(a, b int) bool { return %s[a] < %s[b] })", keys, keys, keys)
+		}
+		e.block("for _, %s := range %s {", k, keys)
+		e.pack(k, t.Key, 0)
+		v := e.newVar("v")
+		e.line("%s := %s[%s]", v, expr, k)
+		e.pack(v, t.Elem, 0)
+		e.end("}")
+
+	case kindPtr:
+		e.block("if %s == nil {", expr)
+		e.line("w.WriteByte(0)")
+		e.end("} else {")
+		e.indent++
+		e.line("w.WriteByte(1)")
+		e.pack("(*"+expr+")", t.Elem, 0)
+		e.end("}")
+
+	case kindStruct:
+		e.block("if err := %s.packTo(w); err != nil {", expr)
+		e.line("return err")
+		e.end("}")
+	}
+}
+
+// sample returns a non-zero literal of type t for the generated round-trip tests.
+// Pointers and containers deeper than maxDepth stay nil so recursive types terminate.
+func sample(t *typeInfo, structs map[string]*structInfo, depth int) string {
+	const maxDepth = 3
+
+	switch t.Kind {
+	case kindInt, kindUint:
+		return t.Go + "(7)"
+	case kindFloat:
+		return t.Go + "(1.5)"
+	case kindBool:
+		return t.Go + "(true)"
+	case kindString:
+		return t.Go + `("str")`
+	case kindBytes:
+		return t.Go + `("bytes")`
+	case kindSlice:
+		if depth >= maxDepth {
+			return "nil"
+		}
+		s := sample(t.Elem, structs, depth+1)
+		return t.Go + "{" + s + ", " + s + "}"
+	case kindArray:
+		return t.Go + "{" + sample(t.Elem, structs, depth+1) + "}"
+	case kindMap:
+		if depth >= maxDepth {
+			return "nil"
+		}
+		return t.Go + "{" + sample(t.Key, structs, depth+1) + ": " + sample(t.Elem, structs, depth+1) + "}"
+	case kindPtr:
+		if depth >= maxDepth {
+			return "nil"
+		}
+		return fmt.Sprintf("func() %s { v := %s; return &v }()", t.Go, sample(t.Elem, structs, depth+1))
+	case kindStruct:
+		st := structs[t.Go]
+		fields := make([]string, 0, len(st.Fields))
+		for _, f := range st.Fields {
+			fields = append(fields, f.Name+": "+sample(f.Type, structs, depth+1))
+		}
+		return t.Go + "{" + strings.Join(fields, ", ") + "}"
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var byteOrders = map[string]string{
+	"le": "binary.LittleEndian",
+	"be": "binary.BigEndian",
+}
+
+var widths = map[string]string{
+	"u8": "uint8", "u16": "uint16", "u32": "uint32", "u64": "uint64",
+	"i8": "int8", "i16": "int16", "i32": "int32", "i64": "int64",
+}
+
+// structOptions follow the mark: "// cgen: binpack be version=2"
+type structOptions struct {
+	Order   string
+	Version int
+}
+
+func parseStructOptions(mark string) (structOptions, error) {
+	opts := structOptions{Order: byteOrders["le"]}
+	for _, opt := range strings.Fields(strings.TrimPrefix(mark, "// cgen: binpack")) {
+		switch {
+		case byteOrders[opt] != "":
+			opts.Order = byteOrders[opt]
+		case strings.HasPrefix(opt, "version="):
+			v, err := strconv.Atoi(strings.TrimPrefix(opt, "version="))
+			if err != nil || v <= 0 || v > 0xFFFF {
+				return opts, fmt.Errorf("bad %s", opt)
+			}
+			opts.Version = v
+		default:
+			return opts, fmt.Errorf("unknown cgen option %q", opt)
+		}
+	}
+	return opts, nil
+}
+
+// fieldOptions come from the tag: `cgen:"be,u16,max=64,since=2"`
+type fieldOptions struct {
+	Max    int
+	Since  int
+	Order  string
+	Wire   string
+	Varint bool
+}
+
+func parseFieldOptions(tag string) (fieldOptions, error) {
+	opts := fieldOptions{}
+	if tag == "" {
+		return opts, nil
+	}
+	for _, opt := range strings.Split(tag, ",") {
+		var err error
+		switch {
+		case byteOrders[opt] != "":
+			opts.Order = byteOrders[opt]
+		case widths[opt] != "":
+			opts.Wire = widths[opt]
+		case opt == "varint":
+			opts.Varint = true
+		case strings.HasPrefix(opt, "max="):
+			opts.Max, err = strconv.Atoi(strings.TrimPrefix(opt, "max="))
+			if err != nil || opts.Max <= 0 {
+				return opts, fmt.Errorf("bad %s", opt)
+			}
+		case strings.HasPrefix(opt, "since="):
+			opts.Since, err = strconv.Atoi(strings.TrimPrefix(opt, "since="))
+			if err != nil || opts.Since <= 0 || opts.Since > 0xFFFF {
+				return opts, fmt.Errorf("bad %s", opt)
+			}
+		default:
+			return opts, fmt.Errorf("unknown cgen option %q", opt)
+		}
+	}
+	if opts.Varint && opts.Wire != "" {
+		return opts, fmt.Errorf("varint and %s are mutually exclusive", opts.Wire)
+	}
+	return opts, nil
+}
+
+// applyOptions sets the byte order of t and its length prefixes and overrides the
+// wire format of every integer inside it. Nested structs keep their own options.
+func applyOptions(t *typeInfo, opts fieldOptions, structOrder string) error {
+	if t == nil || t.Kind == kindStruct {
+		return nil
+	}
+
+	t.Order = structOrder
+	if opts.Order != "" {
+		t.Order = opts.Order
+	}
+
+	switch t.Kind {
+	case kindInt, kindUint:
+		if opts.Wire != "" {
+			t.Wire = opts.Wire
+		}
+		t.Varint = opts.Varint
+	case kindFloat, kindBool:
+		if opts.Wire != "" || opts.Varint {
+			return fmt.Errorf("integer encoding options can't be used with %s", t.Go)
+		}
+	}
+
+	if err := applyOptions(t.Key, opts, structOrder); err != nil {
+		return err
+	}
+	return applyOptions(t.Elem, opts, structOrder)
+}
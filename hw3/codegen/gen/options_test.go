@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFieldOptions(t *testing.T) {
+	cases := []struct {
+		Tag      string
+		Expected fieldOptions
+		Err      bool
+	}{
+		{"", fieldOptions{}, false},
+		{"be,u16,max=64,since=2", fieldOptions{Max: 64, Since: 2, Order: "binary.BigEndian", Wire: "uint16"}, false},
+		{"le,varint", fieldOptions{Order: "binary.LittleEndian", Varint: true}, false},
+		{"i8", fieldOptions{Wire: "int8"}, false},
+		{"max=0", fieldOptions{}, true},
+		{"max=-1", fieldOptions{}, true},
+		{"max=x", fieldOptions{}, true},
+		{"since=0", fieldOptions{}, true},
+		{"since=65536", fieldOptions{}, true},
+		{"u8,varint", fieldOptions{}, true},
+		{"u24", fieldOptions{}, true},
+	}
+	for caseNum, item := range cases {
+		opts, err := parseFieldOptions(item.Tag)
+		if item.Err {
+			if err == nil {
+				t.Errorf("[%d] expected an error for %q", caseNum, item.Tag)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%d] unexpected error for %q: %s", caseNum, item.Tag, err)
+			continue
+		}
+		if opts != item.Expected {
+			t.Errorf("[%d] wrong options for %q\nGot:\n%+v\nExpected:\n%+v", caseNum, item.Tag, opts, item.Expected)
+		}
+	}
+}
+
+func TestParseStructOptions(t *testing.T) {
+	cases := []struct {
+		Mark     string
+		Expected structOptions
+		Err      bool
+	}{
+		{"// cgen: binpack", structOptions{Order: "binary.LittleEndian"}, false},
+		{"// cgen: binpack be version=2", structOptions{Order: "binary.BigEndian", Version: 2}, false},
+		{"// cgen: binpack version=0", structOptions{}, true},
+		{"// cgen: binpack version=70000", structOptions{}, true},
+		{"// cgen: binpack fast", structOptions{}, true},
+	}
+	for caseNum, item := range cases {
+		opts, err := parseStructOptions(item.Mark)
+		if item.Err {
+			if err == nil {
+				t.Errorf("[%d] expected an error for %q", caseNum, item.Mark)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%d] unexpected error for %q: %s", caseNum, item.Mark, err)
+			continue
+		}
+		if opts != item.Expected {
+			t.Errorf("[%d] wrong options for %q\nGot:\n%+v\nExpected:\n%+v", caseNum, item.Mark, opts, item.Expected)
+		}
+	}
+}
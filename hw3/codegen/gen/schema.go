@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// the schema describes the wire layout for decoders in other languages,
+// binpack.DecodeMap reads the same format
+
+type schemaDoc struct {
+	Package string         `json:"package"`
+	Structs []schemaStruct `json:"structs"`
+}
+
+type schemaStruct struct {
+	Name    string        `json:"name"`
+	Order   string        `json:"order"`             // le or be: default for fields and the version header
+	Version int           `json:"version,omitempty"` // uint16 version header goes first when > 0
+	Fields  []schemaField `json:"fields"`
+}
+
+type schemaField struct {
+	Name  string      `json:"name"`
+	Type  *schemaType `json:"type"`
+	Max   int         `json:"max,omitempty"`
+	Since int         `json:"since,omitempty"`
+}
+
+type schemaType struct {
+	Kind   string      `json:"kind"`             // int uint float bool string bytes slice array map ptr struct
+	Go     string      `json:"go"`               // Go type, for reference only
+	Wire   string      `json:"wire,omitempty"`   // numbers: u8..u64, i8..i64, f32, f64, bool, varint, uvarint
+	Order  string      `json:"order,omitempty"`  // numbers and uint32 length prefixes
+	Len    int         `json:"len,omitempty"`    // arrays
+	Elem   *schemaType `json:"elem,omitempty"`   // slices, arrays, maps, pointers
+	Key    *schemaType `json:"key,omitempty"`    // maps
+	Struct string      `json:"struct,omitempty"` // name of a struct from the same schema
+}
+
+var kindNames = map[kind]string{
+	kindInt: "int", kindUint: "uint", kindFloat: "float", kindBool: "bool",
+	kindString: "string", kindBytes: "bytes", kindSlice: "slice", kindArray: "array",
+	kindMap: "map", kindPtr: "ptr", kindStruct: "struct",
+}
+
+var wireNames = map[string]string{
+	"uint8": "u8", "uint16": "u16", "uint32": "u32", "uint64": "u64",
+	"int8": "i8", "int16": "i16", "int32": "i32", "int64": "i64",
+	"float32": "f32", "float64": "f64", "bool": "bool",
+}
+
+func orderName(order string) string {
+	for name, expr := range byteOrders {
+		if expr == order {
+			return name
+		}
+	}
+	return ""
+}
+
+func schemaOf(t *typeInfo) *schemaType {
+	if t == nil {
+		return nil
+	}
+	st := &schemaType{
+		Kind:  kindNames[t.Kind],
+		Go:    t.Go,
+		Order: orderName(t.Order),
+		Len:   t.Len,
+		Elem:  schemaOf(t.Elem),
+		Key:   schemaOf(t.Key),
+	}
+	switch t.Kind {
+	case kindInt, kindUint, kindFloat, kindBool:
+		st.Wire = wireNames[t.Wire]
+		if t.Varint && t.Kind == kindInt {
+			st.Wire = "varint"
+		} else if t.Varint {
+			st.Wire = "uvarint"
+		}
+	case kindArray, kindPtr:
+		st.Order = "" // no length prefix
+	case kindStruct:
+		st.Struct = t.Go
+	}
+	return st
+}
+
+func writeSchema(path, pkg string, structs []structInfo) error {
+	doc := schemaDoc{Package: pkg}
+	for _, st := range structs {
+		s := schemaStruct{Name: st.Name, Order: orderName(st.Order), Version: st.Version}
+		for _, f := range st.Fields {
+			s.Fields = append(s.Fields, schemaField{Name: f.Name, Type: schemaOf(f.Type), Max: f.Max, Since: f.Since})
+		}
+		doc.Structs = append(doc.Structs, s)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
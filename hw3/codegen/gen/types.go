@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strconv"
+)
+
+type kind int
+
+const (
+	kindInt kind = iota
+	kindUint
+	kindFloat
+	kindBool
+	kindString
+	kindBytes
+	kindSlice
+	kindArray
+	kindMap
+	kindPtr
+	kindStruct
+)
+
+// typeInfo describes how a Go type is laid out on the wire
+type typeInfo struct {
+	Kind kind
+	Go   string // Go type expression, used for declarations and conversions
+	Wire string // fixed width numbers and bools: the type that is actually written
+	Len  int    // arrays
+	Size int    // integers: size of the Go type, int and uint are taken as 8 bytes
+	Elem *typeInfo
+	Key  *typeInfo
+
+	Order  string // binary.LittleEndian or binary.BigEndian, for numbers and length prefixes
+	Varint bool   // integers: zigzag varint for signed, uvarint for unsigned
+}
+
+// fixed width wire types of the builtin types. int and uint are written as 4 bytes,
+// as the original templates did.
+var basicTypes = map[string]typeInfo{
+	"int":     {Kind: kindInt, Wire: "uint32", Size: 8},
+	"int8":    {Kind: kindInt, Wire: "int8", Size: 1},
+	"int16":   {Kind: kindInt, Wire: "int16", Size: 2},
+	"int32":   {Kind: kindInt, Wire: "int32", Size: 4},
+	"rune":    {Kind: kindInt, Wire: "int32", Size: 4},
+	"int64":   {Kind: kindInt, Wire: "int64", Size: 8},
+	"uint":    {Kind: kindUint, Wire: "uint32", Size: 8},
+	"uint8":   {Kind: kindUint, Wire: "uint8", Size: 1},
+	"byte":    {Kind: kindUint, Wire: "uint8", Size: 1},
+	"uint16":  {Kind: kindUint, Wire: "uint16", Size: 2},
+	"uint32":  {Kind: kindUint, Wire: "uint32", Size: 4},
+	"uint64":  {Kind: kindUint, Wire: "uint64", Size: 8},
+	"float32": {Kind: kindFloat, Wire: "float32"},
+	"float64": {Kind: kindFloat, Wire: "float64"},
+	"bool":    {Kind: kindBool, Wire: "bool"},
+	"string":  {Kind: kindString},
+}
+
+// resolver turns field types into typeInfo using the declarations of the parsed package
+type resolver struct {
+	structs map[string]bool     // types marked with cgen: binpack
+	decls   map[string]ast.Expr // every type declared in the package
+}
+
+func (res *resolver) resolve(expr ast.Expr) (*typeInfo, error) {
+	return res.resolveNamed(expr, types.ExprString(expr), map[string]bool{})
+}
+
+func (res *resolver) resolveNamed(expr ast.Expr, goType string, seen map[string]bool) (*typeInfo, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if basic, ok := basicTypes[t.Name]; ok {
+			basic.Go = goType
+			return &basic, nil
+		}
+		if res.structs[t.Name] {
+			return &typeInfo{Kind: kindStruct, Go: goType}, nil
+		}
+		underlying, ok := res.decls[t.Name]
+		if !ok || seen[t.Name] {
+			return nil, fmt.Errorf("unsupported type %s", t.Name)
+		}
+		if _, isStruct := underlying.(*ast.StructType); isStruct {
+			return nil, fmt.Errorf("struct %s has no cgen: binpack mark", t.Name)
+		}
+		seen[t.Name] = true
+		return res.resolveNamed(underlying, goType, seen)
+
+	case *ast.StarExpr:
+		elem, err := res.resolve(t.X)
+		if err != nil {
+			return nil, err
+		}
+		return &typeInfo{Kind: kindPtr, Go: goType, Elem: elem}, nil
+
+	case *ast.ArrayType:
+		elem, err := res.resolve(t.Elt)
+		if err != nil {
+			return nil, err
+		}
+		if t.Len == nil {
+			if elem.Go == "byte" || elem.Go == "uint8" {
+				return &typeInfo{Kind: kindBytes, Go: goType}, nil
+			}
+			return &typeInfo{Kind: kindSlice, Go: goType, Elem: elem}, nil
+		}
+		lit, ok := t.Len.(*ast.BasicLit)
+		if !ok {
+			return nil, fmt.Errorf("array length must be a literal: %s", types.ExprString(t.Len))
+		}
+		n, err := strconv.Atoi(lit.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad array length %s", lit.Value)
+		}
+		return &typeInfo{Kind: kindArray, Go: goType, Len: n, Elem: elem}, nil
+
+	case *ast.MapType:
+		key, err := res.resolve(t.Key)
+		if err != nil {
+			return nil, err
+		}
+		switch key.Kind {
+		case kindInt, kindUint, kindFloat, kindBool, kindString:
+		default:
+			return nil, fmt.Errorf("unsupported map key %s", key.Go)
+		}
+		value, err := res.resolve(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &typeInfo{Kind: kindMap, Go: goType, Key: key, Elem: value}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", types.ExprString(expr))
+}
@@ -1,26 +1,645 @@
+// Code generated by binpack codegen. DO NOT EDIT.
+
 package main
 
-import "encoding/binary"
-import "bytes"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var (
+	ErrShortBuffer    = errors.New("binpack: short buffer")
+	ErrLengthExceeded = errors.New("binpack: length exceeded")
+	ErrBadValue       = errors.New("binpack: bad value")
+	ErrVersion        = errors.New("binpack: unsupported version")
+)
+
+// BinpackError tells which field failed to unpack and where it starts in the input
+type BinpackError struct {
+	Field  string
+	Offset int64
+	Err    error
+}
+
+func (e *BinpackError) Error() string {
+	return fmt.Sprintf("binpack: %s at offset %d: %s", e.Field, e.Offset, e.Err)
+}
+
+func (e *BinpackError) Unwrap() error {
+	return e.Err
+}
+
+func binpackError(field string, off int64, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrShortBuffer
+	}
+	return &BinpackError{Field: field, Offset: off, Err: err}
+}
+
+func binpackPutVarint(w *bytes.Buffer, x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutVarint(buf[:], x)])
+}
+
+func binpackPutUvarint(w *bytes.Buffer, x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], x)])
+}
 
 func (in *User) Unpack(data []byte) error {
-	r := bytes.NewReader(data)
+	return in.unpackFrom(bytes.NewReader(data))
+}
+
+func (in *User) unpackFrom(r *bytes.Reader) error {
+	var off int64
 
 	// ID
-	var IDRaw uint32
-	binary.Read(r, binary.LittleEndian, &IDRaw)
-	in.ID = int(IDRaw)
+	off = r.Size() - int64(r.Len())
+	var v1 uint32
+	if err := binary.Read(r, binary.LittleEndian, &v1); err != nil {
+		return binpackError("User.ID", off, err)
+	}
+	in.ID = int(v1)
 
 	// Login
-	var LoginLenRaw uint32
-	binary.Read(r, binary.LittleEndian, &LoginLenRaw)
-	LoginRaw := make([]byte, LoginLenRaw)
-	binary.Read(r, binary.LittleEndian, &LoginRaw)
-	in.Login = string(LoginRaw)
+	off = r.Size() - int64(r.Len())
+	var n2 uint32
+	if err := binary.Read(r, binary.LittleEndian, &n2); err != nil {
+		return binpackError("User.Login", off, err)
+	}
+	if n2 > 64 {
+		return binpackError("User.Login", off, ErrLengthExceeded)
+	}
+	if uint64(n2)*1 > uint64(r.Len()) {
+		return binpackError("User.Login", off, ErrShortBuffer)
+	}
+	v3 := make([]byte, n2)
+	if err := binary.Read(r, binary.LittleEndian, v3); err != nil {
+		return binpackError("User.Login", off, err)
+	}
+	in.Login = string(v3)
 
 	// Flags
-	var FlagsRaw uint32
-	binary.Read(r, binary.LittleEndian, &FlagsRaw)
-	in.Flags = int(FlagsRaw)
+	off = r.Size() - int64(r.Len())
+	var v4 uint32
+	if err := binary.Read(r, binary.LittleEndian, &v4); err != nil {
+		return binpackError("User.Flags", off, err)
+	}
+	in.Flags = int(v4)
+	return nil
+}
+
+func (in *User) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	err := in.packTo(w)
+	return w.Bytes(), err
+}
+
+func (in *User) packTo(w *bytes.Buffer) error {
+
+	// ID
+	if int(uint32(in.ID)) != in.ID {
+		return binpackError("User.ID", int64(w.Len()), ErrBadValue)
+	}
+	binary.Write(w, binary.LittleEndian, uint32(in.ID))
+
+	// Login
+	if len(in.Login) > 64 {
+		return binpackError("User.Login", int64(w.Len()), ErrLengthExceeded)
+	}
+	binary.Write(w, binary.LittleEndian, uint32(len(in.Login)))
+	w.WriteString(string(in.Login))
+
+	// Flags
+	if int(uint32(in.Flags)) != in.Flags {
+		return binpackError("User.Flags", int64(w.Len()), ErrBadValue)
+	}
+	binary.Write(w, binary.LittleEndian, uint32(in.Flags))
+	return nil
+}
+
+func (in *User) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+func (in *User) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
+
+func (in *Header) Unpack(data []byte) error {
+	return in.unpackFrom(bytes.NewReader(data))
+}
+
+func (in *Header) unpackFrom(r *bytes.Reader) error {
+	var off int64
+
+	// Magic
+	off = r.Size() - int64(r.Len())
+	var v5 uint32
+	if err := binary.Read(r, binary.BigEndian, &v5); err != nil {
+		return binpackError("Header.Magic", off, err)
+	}
+	in.Magic = uint32(v5)
+
+	// Length
+	off = r.Size() - int64(r.Len())
+	var v6 uint16
+	if err := binary.Read(r, binary.LittleEndian, &v6); err != nil {
+		return binpackError("Header.Length", off, err)
+	}
+	in.Length = uint16(v6)
+
+	// Seq
+	off = r.Size() - int64(r.Len())
+	v7, err := binary.ReadVarint(r)
+	if err != nil {
+		return binpackError("Header.Seq", off, err)
+	}
+	in.Seq = int64(v7)
+
+	// Code
+	off = r.Size() - int64(r.Len())
+	var v8 uint8
+	if err := binary.Read(r, binary.BigEndian, &v8); err != nil {
+		return binpackError("Header.Code", off, err)
+	}
+	in.Code = int(v8)
+
+	// Hops
+	off = r.Size() - int64(r.Len())
+	var n9 uint32
+	if err := binary.Read(r, binary.BigEndian, &n9); err != nil {
+		return binpackError("Header.Hops", off, err)
+	}
+	if n9 > 8 {
+		return binpackError("Header.Hops", off, ErrLengthExceeded)
+	}
+	if uint64(n9)*1 > uint64(r.Len()) {
+		return binpackError("Header.Hops", off, ErrShortBuffer)
+	}
+	if n9 > 0 {
+		in.Hops = make([]uint, n9)
+		for i10 := range in.Hops {
+			v11, err := binary.ReadUvarint(r)
+			if err != nil {
+				return binpackError("Header.Hops", off, err)
+			}
+			in.Hops[i10] = uint(v11)
+		}
+	}
 	return nil
 }
+
+func (in *Header) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	err := in.packTo(w)
+	return w.Bytes(), err
+}
+
+func (in *Header) packTo(w *bytes.Buffer) error {
+
+	// Magic
+	binary.Write(w, binary.BigEndian, uint32(in.Magic))
+
+	// Length
+	binary.Write(w, binary.LittleEndian, uint16(in.Length))
+
+	// Seq
+	binpackPutVarint(w, int64(in.Seq))
+
+	// Code
+	if int(uint8(in.Code)) != in.Code {
+		return binpackError("Header.Code", int64(w.Len()), ErrBadValue)
+	}
+	binary.Write(w, binary.BigEndian, uint8(in.Code))
+
+	// Hops
+	if len(in.Hops) > 8 {
+		return binpackError("Header.Hops", int64(w.Len()), ErrLengthExceeded)
+	}
+	binary.Write(w, binary.BigEndian, uint32(len(in.Hops)))
+	for _, v12 := range in.Hops {
+		binpackPutUvarint(w, uint64(v12))
+	}
+	return nil
+}
+
+func (in *Header) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+func (in *Header) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
+
+func (in *Session) Unpack(data []byte) error {
+	return in.unpackFrom(bytes.NewReader(data))
+}
+
+func (in *Session) unpackFrom(r *bytes.Reader) error {
+	var off int64
+
+	// version header
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return binpackError("Session.version", off, err)
+	}
+	if version == 0 || version > 2 {
+		return binpackError("Session.version", off, ErrVersion)
+	}
+
+	// ID
+	off = r.Size() - int64(r.Len())
+	v13, err := binary.ReadVarint(r)
+	if err != nil {
+		return binpackError("Session.ID", off, err)
+	}
+	in.ID = int(v13)
+
+	// Token
+	off = r.Size() - int64(r.Len())
+	var n14 uint32
+	if err := binary.Read(r, binary.LittleEndian, &n14); err != nil {
+		return binpackError("Session.Token", off, err)
+	}
+	if uint64(n14)*1 > uint64(r.Len()) {
+		return binpackError("Session.Token", off, ErrShortBuffer)
+	}
+	v15 := make([]byte, n14)
+	if err := binary.Read(r, binary.LittleEndian, v15); err != nil {
+		return binpackError("Session.Token", off, err)
+	}
+	in.Token = string(v15)
+
+	// Expires
+	if version >= 2 {
+		off = r.Size() - int64(r.Len())
+		var v16 uint64
+		if err := binary.Read(r, binary.LittleEndian, &v16); err != nil {
+			return binpackError("Session.Expires", off, err)
+		}
+		in.Expires = uint64(v16)
+	}
+	return nil
+}
+
+func (in *Session) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	err := in.packTo(w)
+	return w.Bytes(), err
+}
+
+func (in *Session) packTo(w *bytes.Buffer) error {
+
+	// version header
+	binary.Write(w, binary.LittleEndian, uint16(2))
+
+	// ID
+	binpackPutVarint(w, int64(in.ID))
+
+	// Token
+	binary.Write(w, binary.LittleEndian, uint32(len(in.Token)))
+	w.WriteString(string(in.Token))
+
+	// Expires
+	binary.Write(w, binary.LittleEndian, uint64(in.Expires))
+	return nil
+}
+
+func (in *Session) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+func (in *Session) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
+
+func (in *SessionList) Unpack(data []byte) error {
+	return in.unpackFrom(bytes.NewReader(data))
+}
+
+func (in *SessionList) unpackFrom(r *bytes.Reader) error {
+	var off int64
+
+	// Items
+	off = r.Size() - int64(r.Len())
+	var n17 uint32
+	if err := binary.Read(r, binary.LittleEndian, &n17); err != nil {
+		return binpackError("SessionList.Items", off, err)
+	}
+	if uint64(n17)*7 > uint64(r.Len()) {
+		return binpackError("SessionList.Items", off, ErrShortBuffer)
+	}
+	if n17 > 0 {
+		in.Items = make([]Session, n17)
+		for i18 := range in.Items {
+			if err := in.Items[i18].unpackFrom(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (in *SessionList) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	err := in.packTo(w)
+	return w.Bytes(), err
+}
+
+func (in *SessionList) packTo(w *bytes.Buffer) error {
+
+	// Items
+	binary.Write(w, binary.LittleEndian, uint32(len(in.Items)))
+	for _, v19 := range in.Items {
+		if err := v19.packTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *SessionList) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+func (in *SessionList) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
+
+func (in *Profile) Unpack(data []byte) error {
+	return in.unpackFrom(bytes.NewReader(data))
+}
+
+func (in *Profile) unpackFrom(r *bytes.Reader) error {
+	var off int64
+
+	// Owner
+	if err := in.Owner.unpackFrom(r); err != nil {
+		return err
+	}
+
+	// Age
+	off = r.Size() - int64(r.Len())
+	var v20 uint8
+	if err := binary.Read(r, binary.LittleEndian, &v20); err != nil {
+		return binpackError("Profile.Age", off, err)
+	}
+	in.Age = uint8(v20)
+
+	// Rating
+	off = r.Size() - int64(r.Len())
+	var v21 float64
+	if err := binary.Read(r, binary.LittleEndian, &v21); err != nil {
+		return binpackError("Profile.Rating", off, err)
+	}
+	in.Rating = float64(v21)
+
+	// Verified
+	off = r.Size() - int64(r.Len())
+	var v22 bool
+	if err := binary.Read(r, binary.LittleEndian, &v22); err != nil {
+		return binpackError("Profile.Verified", off, err)
+	}
+	in.Verified = bool(v22)
+
+	// Photo
+	off = r.Size() - int64(r.Len())
+	var n23 uint32
+	if err := binary.Read(r, binary.LittleEndian, &n23); err != nil {
+		return binpackError("Profile.Photo", off, err)
+	}
+	if uint64(n23)*1 > uint64(r.Len()) {
+		return binpackError("Profile.Photo", off, ErrShortBuffer)
+	}
+	v24 := make([]byte, n23)
+	if err := binary.Read(r, binary.LittleEndian, v24); err != nil {
+		return binpackError("Profile.Photo", off, err)
+	}
+	if n23 > 0 {
+		in.Photo = []byte(v24)
+	}
+
+	// Tags
+	off = r.Size() - int64(r.Len())
+	var n25 uint32
+	if err := binary.Read(r, binary.LittleEndian, &n25); err != nil {
+		return binpackError("Profile.Tags", off, err)
+	}
+	if n25 > 16 {
+		return binpackError("Profile.Tags", off, ErrLengthExceeded)
+	}
+	if uint64(n25)*4 > uint64(r.Len()) {
+		return binpackError("Profile.Tags", off, ErrShortBuffer)
+	}
+	if n25 > 0 {
+		in.Tags = make([]string, n25)
+		for i26 := range in.Tags {
+			var n27 uint32
+			if err := binary.Read(r, binary.LittleEndian, &n27); err != nil {
+				return binpackError("Profile.Tags", off, err)
+			}
+			if uint64(n27)*1 > uint64(r.Len()) {
+				return binpackError("Profile.Tags", off, ErrShortBuffer)
+			}
+			v28 := make([]byte, n27)
+			if err := binary.Read(r, binary.LittleEndian, v28); err != nil {
+				return binpackError("Profile.Tags", off, err)
+			}
+			in.Tags[i26] = string(v28)
+		}
+	}
+
+	// Scores
+	off = r.Size() - int64(r.Len())
+	for i29 := range in.Scores {
+		var v30 int16
+		if err := binary.Read(r, binary.LittleEndian, &v30); err != nil {
+			return binpackError("Profile.Scores", off, err)
+		}
+		in.Scores[i29] = int16(v30)
+	}
+
+	// Manager
+	off = r.Size() - int64(r.Len())
+	var present31 uint8
+	if err := binary.Read(r, binary.LittleEndian, &present31); err != nil {
+		return binpackError("Profile.Manager", off, err)
+	}
+	if present31 > 1 {
+		return binpackError("Profile.Manager", off, ErrBadValue)
+	}
+	if present31 == 1 {
+		in.Manager = new(User)
+		if err := (*in.Manager).unpackFrom(r); err != nil {
+			return err
+		}
+	}
+
+	// Settings
+	off = r.Size() - int64(r.Len())
+	var n32 uint32
+	if err := binary.Read(r, binary.LittleEndian, &n32); err != nil {
+		return binpackError("Profile.Settings", off, err)
+	}
+	if uint64(n32)*12 > uint64(r.Len()) {
+		return binpackError("Profile.Settings", off, ErrShortBuffer)
+	}
+	if n32 > 0 {
+		in.Settings = make(map[string]int64, n32)
+		for i33 := uint32(0); i33 < n32; i33++ {
+			var k34 string
+			var n36 uint32
+			if err := binary.Read(r, binary.LittleEndian, &n36); err != nil {
+				return binpackError("Profile.Settings", off, err)
+			}
+			if uint64(n36)*1 > uint64(r.Len()) {
+				return binpackError("Profile.Settings", off, ErrShortBuffer)
+			}
+			v37 := make([]byte, n36)
+			if err := binary.Read(r, binary.LittleEndian, v37); err != nil {
+				return binpackError("Profile.Settings", off, err)
+			}
+			k34 = string(v37)
+			var v35 int64
+			var v38 int64
+			if err := binary.Read(r, binary.LittleEndian, &v38); err != nil {
+				return binpackError("Profile.Settings", off, err)
+			}
+			v35 = int64(v38)
+			in.Settings[k34] = v35
+		}
+	}
+
+	// Role
+	off = r.Size() - int64(r.Len())
+	var v39 uint16
+	if err := binary.Read(r, binary.LittleEndian, &v39); err != nil {
+		return binpackError("Profile.Role", off, err)
+	}
+	in.Role = Role(v39)
+
+	// Friends
+	off = r.Size() - int64(r.Len())
+	var n40 uint32
+	if err := binary.Read(r, binary.LittleEndian, &n40); err != nil {
+		return binpackError("Profile.Friends", off, err)
+	}
+	if uint64(n40)*1 > uint64(r.Len()) {
+		return binpackError("Profile.Friends", off, ErrShortBuffer)
+	}
+	if n40 > 0 {
+		in.Friends = make([]*Profile, n40)
+		for i41 := range in.Friends {
+			var present42 uint8
+			if err := binary.Read(r, binary.LittleEndian, &present42); err != nil {
+				return binpackError("Profile.Friends", off, err)
+			}
+			if present42 > 1 {
+				return binpackError("Profile.Friends", off, ErrBadValue)
+			}
+			if present42 == 1 {
+				in.Friends[i41] = new(Profile)
+				if err := (*in.Friends[i41]).unpackFrom(r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (in *Profile) Pack() ([]byte, error) {
+	w := new(bytes.Buffer)
+	err := in.packTo(w)
+	return w.Bytes(), err
+}
+
+func (in *Profile) packTo(w *bytes.Buffer) error {
+
+	// Owner
+	if err := in.Owner.packTo(w); err != nil {
+		return err
+	}
+
+	// Age
+	binary.Write(w, binary.LittleEndian, uint8(in.Age))
+
+	// Rating
+	binary.Write(w, binary.LittleEndian, float64(in.Rating))
+
+	// Verified
+	binary.Write(w, binary.LittleEndian, bool(in.Verified))
+
+	// Photo
+	binary.Write(w, binary.LittleEndian, uint32(len(in.Photo)))
+	w.Write(in.Photo)
+
+	// Tags
+	if len(in.Tags) > 16 {
+		return binpackError("Profile.Tags", int64(w.Len()), ErrLengthExceeded)
+	}
+	binary.Write(w, binary.LittleEndian, uint32(len(in.Tags)))
+	for _, v43 := range in.Tags {
+		binary.Write(w, binary.LittleEndian, uint32(len(v43)))
+		w.WriteString(string(v43))
+	}
+
+	// Scores
+	for _, v44 := range in.Scores {
+		binary.Write(w, binary.LittleEndian, int16(v44))
+	}
+
+	// Manager
+	if in.Manager == nil {
+		w.WriteByte(0)
+	} else {
+		w.WriteByte(1)
+		if err := (*in.Manager).packTo(w); err != nil {
+			return err
+		}
+	}
+
+	// Settings
+	binary.Write(w, binary.LittleEndian, uint32(len(in.Settings)))
+	keys45 := make([]string, 0, len(in.Settings))
+	for k46 := range in.Settings {
+		keys45 = append(keys45, k46)
+	}
+	sort.Slice(keys45, func(a, b int) bool { return keys45[a] < keys45[b] })
+	for _, k46 := range keys45 {
+		binary.Write(w, binary.LittleEndian, uint32(len(k46)))
+		w.WriteString(string(k46))
+		v47 := in.Settings[k46]
+		binary.Write(w, binary.LittleEndian, int64(v47))
+	}
+
+	// Role
+	binary.Write(w, binary.LittleEndian, uint16(in.Role))
+
+	// Friends
+	binary.Write(w, binary.LittleEndian, uint32(len(in.Friends)))
+	for _, v48 := range in.Friends {
+		if v48 == nil {
+			w.WriteByte(0)
+		} else {
+			w.WriteByte(1)
+			if err := (*v48).packTo(w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (in *Profile) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+func (in *Profile) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
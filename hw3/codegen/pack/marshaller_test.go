@@ -0,0 +1,333 @@
+// Code generated by binpack codegen. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUserBinpackRoundTrip(t *testing.T) {
+	in := &User{
+		ID:    int(7),
+		Login: string("str"),
+		Flags: int(7),
+	}
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+
+	out := &User{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch\nGot:\n%#v\nExpected:\n%#v", out, in)
+	}
+}
+
+func FuzzUserUnpack(f *testing.F) {
+	seed := &User{
+		ID:    int(7),
+		Login: string("str"),
+		Flags: int(7),
+	}
+	data, err := seed.Pack()
+	if err != nil {
+		f.Fatalf("Pack: %s", err)
+	}
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v := &User{}
+		if err := v.Unpack(data); err != nil {
+			binpackErr := &BinpackError{}
+			if !errors.As(err, &binpackErr) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+
+		packed, err := v.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked value: %s", err)
+		}
+		again := &User{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of repacked value: %s", err)
+		}
+		repacked, _ := again.Pack()
+		if !bytes.Equal(packed, repacked) {
+			t.Errorf("repack mismatch\nGot:\n%v\nExpected:\n%v", repacked, packed)
+		}
+	})
+}
+
+func TestHeaderBinpackRoundTrip(t *testing.T) {
+	in := &Header{
+		Magic:  uint32(7),
+		Length: uint16(7),
+		Seq:    int64(7),
+		Code:   int(7),
+		Hops:   []uint{uint(7), uint(7)},
+	}
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+
+	out := &Header{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch\nGot:\n%#v\nExpected:\n%#v", out, in)
+	}
+}
+
+func FuzzHeaderUnpack(f *testing.F) {
+	seed := &Header{
+		Magic:  uint32(7),
+		Length: uint16(7),
+		Seq:    int64(7),
+		Code:   int(7),
+		Hops:   []uint{uint(7), uint(7)},
+	}
+	data, err := seed.Pack()
+	if err != nil {
+		f.Fatalf("Pack: %s", err)
+	}
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v := &Header{}
+		if err := v.Unpack(data); err != nil {
+			binpackErr := &BinpackError{}
+			if !errors.As(err, &binpackErr) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+
+		packed, err := v.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked value: %s", err)
+		}
+		again := &Header{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of repacked value: %s", err)
+		}
+		repacked, _ := again.Pack()
+		if !bytes.Equal(packed, repacked) {
+			t.Errorf("repack mismatch\nGot:\n%v\nExpected:\n%v", repacked, packed)
+		}
+	})
+}
+
+func TestSessionBinpackRoundTrip(t *testing.T) {
+	in := &Session{
+		ID:      int(7),
+		Token:   string("str"),
+		Expires: uint64(7),
+	}
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+
+	out := &Session{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch\nGot:\n%#v\nExpected:\n%#v", out, in)
+	}
+}
+
+func FuzzSessionUnpack(f *testing.F) {
+	seed := &Session{
+		ID:      int(7),
+		Token:   string("str"),
+		Expires: uint64(7),
+	}
+	data, err := seed.Pack()
+	if err != nil {
+		f.Fatalf("Pack: %s", err)
+	}
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v := &Session{}
+		if err := v.Unpack(data); err != nil {
+			binpackErr := &BinpackError{}
+			if !errors.As(err, &binpackErr) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+
+		packed, err := v.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked value: %s", err)
+		}
+		again := &Session{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of repacked value: %s", err)
+		}
+		repacked, _ := again.Pack()
+		if !bytes.Equal(packed, repacked) {
+			t.Errorf("repack mismatch\nGot:\n%v\nExpected:\n%v", repacked, packed)
+		}
+	})
+}
+
+func TestSessionListBinpackRoundTrip(t *testing.T) {
+	in := &SessionList{
+		Items: []Session{Session{ID: int(7), Token: string("str"), Expires: uint64(7)}, Session{ID: int(7), Token: string("str"), Expires: uint64(7)}},
+	}
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+
+	out := &SessionList{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch\nGot:\n%#v\nExpected:\n%#v", out, in)
+	}
+}
+
+func FuzzSessionListUnpack(f *testing.F) {
+	seed := &SessionList{
+		Items: []Session{Session{ID: int(7), Token: string("str"), Expires: uint64(7)}, Session{ID: int(7), Token: string("str"), Expires: uint64(7)}},
+	}
+	data, err := seed.Pack()
+	if err != nil {
+		f.Fatalf("Pack: %s", err)
+	}
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v := &SessionList{}
+		if err := v.Unpack(data); err != nil {
+			binpackErr := &BinpackError{}
+			if !errors.As(err, &binpackErr) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+
+		packed, err := v.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked value: %s", err)
+		}
+		again := &SessionList{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of repacked value: %s", err)
+		}
+		repacked, _ := again.Pack()
+		if !bytes.Equal(packed, repacked) {
+			t.Errorf("repack mismatch\nGot:\n%v\nExpected:\n%v", repacked, packed)
+		}
+	})
+}
+
+func TestProfileBinpackRoundTrip(t *testing.T) {
+	in := &Profile{
+		Owner:    User{ID: int(7), Login: string("str"), Flags: int(7)},
+		Age:      uint8(7),
+		Rating:   float64(1.5),
+		Verified: bool(true),
+		Photo:    []byte("bytes"),
+		Tags:     []string{string("str"), string("str")},
+		Scores:   [3]int16{int16(7)},
+		Manager:  func() *User { v := User{ID: int(7), Login: string("str"), Flags: int(7)}; return &v }(),
+		Settings: map[string]int64{string("str"): int64(7)},
+		Role:     Role(7),
+		Friends: []*Profile{func() *Profile {
+			v := Profile{Owner: User{ID: int(7), Login: string("str"), Flags: int(7)}, Age: uint8(7), Rating: float64(1.5), Verified: bool(true), Photo: []byte("bytes"), Tags: nil, Scores: [3]int16{int16(7)}, Manager: nil, Settings: nil, Role: Role(7), Friends: nil}
+			return &v
+		}(), func() *Profile {
+			v := Profile{Owner: User{ID: int(7), Login: string("str"), Flags: int(7)}, Age: uint8(7), Rating: float64(1.5), Verified: bool(true), Photo: []byte("bytes"), Tags: nil, Scores: [3]int16{int16(7)}, Manager: nil, Settings: nil, Role: Role(7), Friends: nil}
+			return &v
+		}()},
+	}
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+
+	out := &Profile{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch\nGot:\n%#v\nExpected:\n%#v", out, in)
+	}
+}
+
+func FuzzProfileUnpack(f *testing.F) {
+	seed := &Profile{
+		Owner:    User{ID: int(7), Login: string("str"), Flags: int(7)},
+		Age:      uint8(7),
+		Rating:   float64(1.5),
+		Verified: bool(true),
+		Photo:    []byte("bytes"),
+		Tags:     []string{string("str"), string("str")},
+		Scores:   [3]int16{int16(7)},
+		Manager:  func() *User { v := User{ID: int(7), Login: string("str"), Flags: int(7)}; return &v }(),
+		Settings: map[string]int64{string("str"): int64(7)},
+		Role:     Role(7),
+		Friends: []*Profile{func() *Profile {
+			v := Profile{Owner: User{ID: int(7), Login: string("str"), Flags: int(7)}, Age: uint8(7), Rating: float64(1.5), Verified: bool(true), Photo: []byte("bytes"), Tags: nil, Scores: [3]int16{int16(7)}, Manager: nil, Settings: nil, Role: Role(7), Friends: nil}
+			return &v
+		}(), func() *Profile {
+			v := Profile{Owner: User{ID: int(7), Login: string("str"), Flags: int(7)}, Age: uint8(7), Rating: float64(1.5), Verified: bool(true), Photo: []byte("bytes"), Tags: nil, Scores: [3]int16{int16(7)}, Manager: nil, Settings: nil, Role: Role(7), Friends: nil}
+			return &v
+		}()},
+	}
+	data, err := seed.Pack()
+	if err != nil {
+		f.Fatalf("Pack: %s", err)
+	}
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v := &Profile{}
+		if err := v.Unpack(data); err != nil {
+			binpackErr := &BinpackError{}
+			if !errors.As(err, &binpackErr) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+
+		packed, err := v.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked value: %s", err)
+		}
+		again := &Profile{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of repacked value: %s", err)
+		}
+		repacked, _ := again.Pack()
+		if !bytes.Equal(packed, repacked) {
+			t.Errorf("repack mismatch\nGot:\n%v\nExpected:\n%v", repacked, packed)
+		}
+	})
+}
@@ -1,4 +1,5 @@
-// go build gen/* && ./codegen.exe pack/packer.go  pack/marshaller.go
+//go:generate go run ../gen -output marshaller.go -schema schema.json .
+
 package main
 
 import "fmt"
@@ -8,7 +9,7 @@ import "fmt"
 type User struct {
 	ID       int
 	RealName string `cgen:"-"`
-	Login    string
+	Login    string `cgen:"max=64"`
 	Flags    int
 }
 
@@ -17,6 +18,46 @@ type Avatar struct {
 	Url string
 }
 
+type Role uint16
+
+// network byte order header, compact integers where it matters
+// cgen: binpack be
+type Header struct {
+	Magic  uint32
+	Length uint16 `cgen:"le"`
+	Seq    int64  `cgen:"varint"`
+	Code   int    `cgen:"u8"`
+	Hops   []uint `cgen:"varint,max=8"`
+}
+
+// cgen: binpack version=2
+type Session struct {
+	ID      int `cgen:"varint"`
+	Token   string
+	Expires uint64 `cgen:"since=2"`
+}
+
+// sessions of different versions may share a list
+// cgen: binpack
+type SessionList struct {
+	Items []Session
+}
+
+// cgen: binpack
+type Profile struct {
+	Owner    User
+	Age      uint8
+	Rating   float64
+	Verified bool
+	Photo    []byte
+	Tags     []string `cgen:"max=16"`
+	Scores   [3]int16
+	Manager  *User
+	Settings map[string]int64
+	Role     Role
+	Friends  []*Profile
+}
+
 var test = 42
 
 func main() {
@@ -35,5 +76,8 @@ func main() {
 
 	u := User{}
 	u.Unpack(data)
-	fmt.Printf("Unpacked user %#v", u)
+	fmt.Printf("Unpacked user %#v\n", u)
+
+	packed, _ := u.Pack()
+	fmt.Printf("Packed user %v\n", packed)
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestUnpackErrors(t *testing.T) {
+	valid := []byte{
+		128, 36, 17, 0,
+		9, 0, 0, 0,
+		118, 46, 114, 111, 109, 97, 110, 111, 118,
+		16, 0, 0, 0,
+	}
+
+	cases := []struct {
+		Data   []byte
+		Err    error
+		Field  string
+		Offset int64
+	}{
+		{valid[:2], ErrShortBuffer, "User.ID", 0},
+		{valid[:10], ErrShortBuffer, "User.Login", 4},
+		{valid[:19], ErrShortBuffer, "User.Flags", 17},
+		// 4Gb login must fail before anything is allocated
+		{[]byte{0, 0, 0, 0, 255, 255, 255, 255}, ErrLengthExceeded, "User.Login", 4},
+		{[]byte{0, 0, 0, 0, 60, 0, 0, 0}, ErrShortBuffer, "User.Login", 4},
+	}
+
+	for caseNum, item := range cases {
+		err := (&User{}).Unpack(item.Data)
+		binpackErr := &BinpackError{}
+		if !errors.Is(err, item.Err) || !errors.As(err, &binpackErr) {
+			t.Errorf("[%d] expected %v, got %v", caseNum, item.Err, err)
+			continue
+		}
+		if binpackErr.Field != item.Field || binpackErr.Offset != item.Offset {
+			t.Errorf("[%d] wrong position: %s at %d", caseNum, binpackErr.Field, binpackErr.Offset)
+		}
+	}
+
+	u := &User{Login: string(bytes.Repeat([]byte("a"), 65))}
+	if _, err := u.Pack(); !errors.Is(err, ErrLengthExceeded) {
+		t.Errorf("expected ErrLengthExceeded from Pack, got %v", err)
+	}
+
+	// Code is u8 on the wire, 300 would come back as 44
+	h := &Header{Code: 300}
+	binpackErr := &BinpackError{}
+	if _, err := h.Pack(); !errors.Is(err, ErrBadValue) || !errors.As(err, &binpackErr) || binpackErr.Field != "Header.Code" {
+		t.Errorf("expected ErrBadValue for Header.Code from Pack, got %v", err)
+	}
+}
+
+func TestHeaderLayout(t *testing.T) {
+	h := &Header{Magic: 0xCAFE, Length: 0x0102, Seq: -1, Code: 7, Hops: []uint{300}}
+	data, err := h.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0, 0, 0xCA, 0xFE, // big endian by default
+		0x02, 0x01, // le on the field
+		1,          // zigzag varint -1
+		7,          // u8
+		0, 0, 0, 1, // length prefix follows the struct order
+		0xAC, 0x02, // uvarint 300
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("wrong layout\nGot:\n%v\nExpected:\n%v", data, expected)
+	}
+}
+
+func TestSessionVersions(t *testing.T) {
+	v1 := []byte{
+		1, 0, // version 1
+		84,         // varint 42
+		2, 0, 0, 0, // Token
+		'o', 'k',
+	}
+	s := &Session{}
+	if err := s.Unpack(v1); err != nil {
+		t.Fatalf("old payload: %s", err)
+	}
+	if s.ID != 42 || s.Token != "ok" || s.Expires != 0 {
+		t.Errorf("wrong old payload decode: %#v", s)
+	}
+
+	s.Expires = 1700000000
+	data, _ := s.Pack()
+	if data[0] != 2 {
+		t.Errorf("expected version 2 header, got %v", data[:2])
+	}
+	decoded := &Session{}
+	if err := decoded.Unpack(data); err != nil || *decoded != *s {
+		t.Errorf("round trip: %#v %v", decoded, err)
+	}
+
+	if err := (&Session{}).Unpack([]byte{3, 0}); !errors.Is(err, ErrVersion) {
+		t.Errorf("expected ErrVersion for a newer payload, got %v", err)
+	}
+}
+
+func TestSessionListOldVersions(t *testing.T) {
+	// a list of version 1 records is shorter than the newest version would be
+	data := []byte{
+		2, 0, 0, 0,
+		1, 0, 2, 0, 0, 0, 0,
+		1, 0, 4, 0, 0, 0, 0,
+	}
+	list := &SessionList{}
+	if err := list.Unpack(data); err != nil {
+		t.Fatalf("old records in a slice: %s", err)
+	}
+	if len(list.Items) != 2 || list.Items[0].ID != 1 || list.Items[1].ID != 2 {
+		t.Errorf("wrong decode: %#v", list)
+	}
+}
@@ -0,0 +1,346 @@
+// Package jsonpath evaluates JSONPath-like expressions over documents unpacked
+// into interface{} by encoding/json, as in dynamic.go.
+//
+// Supported syntax:
+//
+//	$.users[0].id      child fields and array indexes, $ is optional
+//	users[-1]          negative index counts from the end
+//	users[*].name      wildcard over array items or object values
+//	$['user name']     quoted field names
+//	$..id              recursive descent
+//
+// Typed getters coerce inconsistent upstream values: "17" and 17 are both Int 17,
+// null is reported as ErrNull so callers can tell it from a missing field.
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotFound = errors.New("jsonpath: no value")
+	ErrNull     = errors.New("jsonpath: value is null")
+)
+
+// CoercionError means the value exists but can't be converted to the requested type
+type CoercionError struct {
+	Path  string
+	Value interface{}
+	To    string
+}
+
+func (e *CoercionError) Error() string {
+	return fmt.Sprintf("jsonpath: %s: cannot use %#v as %s", e.Path, e.Value, e.To)
+}
+
+// SyntaxError points to the position in the expression that failed to parse
+type SyntaxError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("jsonpath: %s at %d in %q", e.Msg, e.Pos, e.Expr)
+}
+
+type stepKind int
+
+const (
+	stepField stepKind = iota
+	stepIndex
+	stepWildcard
+	stepDescend // ..name, ..* is stored with wildcard = true
+)
+
+type step struct {
+	kind     stepKind
+	name     string
+	index    int
+	wildcard bool
+}
+
+type Path struct {
+	expr  string
+	steps []step
+}
+
+func MustCompile(expr string) *Path {
+	p, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func Compile(expr string) (*Path, error) {
+	p := &Path{expr: expr}
+	i := 0
+	if strings.HasPrefix(expr, "$") {
+		i++
+	}
+	fail := func(pos int, msg string) (*Path, error) {
+		return nil, &SyntaxError{Expr: expr, Pos: pos, Msg: msg}
+	}
+
+	for i < len(expr) {
+		switch {
+		case strings.HasPrefix(expr[i:], ".."):
+			i += 2
+			name, n := readName(expr[i:])
+			if name == "" {
+				return fail(i, "expected field name after ..")
+			}
+			p.steps = append(p.steps, step{kind: stepDescend, name: name, wildcard: name == "*"})
+			i += n
+
+		case expr[i] == '.' || (i == 0 && expr[i] != '['):
+			if expr[i] == '.' {
+				i++
+			}
+			name, n := readName(expr[i:])
+			if name == "" {
+				return fail(i, "expected field name")
+			}
+			if name == "*" {
+				p.steps = append(p.steps, step{kind: stepWildcard})
+			} else {
+				p.steps = append(p.steps, step{kind: stepField, name: name})
+			}
+			i += n
+
+		case expr[i] == '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return fail(i, "unclosed [")
+			}
+			inner := strings.TrimSpace(expr[i+1 : i+end])
+			switch {
+			case inner == "*":
+				p.steps = append(p.steps, step{kind: stepWildcard})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				p.steps = append(p.steps, step{kind: stepField, name: inner[1 : len(inner)-1]})
+			default:
+				idx, err := strconv.Atoi(inner)
+				if err != nil {
+					return fail(i+1, "bad index "+strconv.Quote(inner))
+				}
+				p.steps = append(p.steps, step{kind: stepIndex, index: idx})
+			}
+			i += end + 1
+
+		default:
+			return fail(i, "unexpected "+strconv.Quote(expr[i:i+1]))
+		}
+	}
+	return p, nil
+}
+
+func readName(s string) (string, int) {
+	n := 0
+	for n < len(s) && s[n] != '.' && s[n] != '[' {
+		n++
+	}
+	return s[:n], n
+}
+
+// Expr returns the source expression
+func (p *Path) Expr() string {
+	return p.expr
+}
+
+// Find returns every value matched by the path, in document order
+// (object keys are visited sorted so the result is deterministic)
+func (p *Path) Find(doc interface{}) []interface{} {
+	current := []interface{}{doc}
+	for _, s := range p.steps {
+		next := make([]interface{}, 0, len(current))
+		for _, v := range current {
+			next = s.apply(v, next)
+		}
+		current = next
+	}
+	return current
+}
+
+func (s step) apply(v interface{}, out []interface{}) []interface{} {
+	switch s.kind {
+	case stepField:
+		if obj, ok := v.(map[string]interface{}); ok {
+			if child, ok := obj[s.name]; ok {
+				out = append(out, child)
+			}
+		}
+	case stepIndex:
+		if arr, ok := v.([]interface{}); ok {
+			idx := s.index
+			if idx < 0 {
+				idx += len(arr)
+			}
+			if idx >= 0 && idx < len(arr) {
+				out = append(out, arr[idx])
+			}
+		}
+	case stepWildcard:
+		out = append(out, children(v)...)
+	case stepDescend:
+		if s.wildcard {
+			out = append(out, children(v)...)
+		} else {
+			out = step{kind: stepField, name: s.name}.apply(v, out)
+		}
+		for _, child := range children(v) {
+			out = s.apply(child, out)
+		}
+	}
+	return out
+}
+
+func children(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		return v
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		result := make([]interface{}, len(keys))
+		for i, k := range keys {
+			result[i] = v[k]
+		}
+		return result
+	}
+	return nil
+}
+
+// First returns the first matched value or ErrNotFound
+func (p *Path) First(doc interface{}) (interface{}, error) {
+	found := p.Find(doc)
+	if len(found) == 0 {
+		return nil, ErrNotFound
+	}
+	return found[0], nil
+}
+
+func (p *Path) first(doc interface{}) (interface{}, error) {
+	v, err := p.First(doc)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNull
+	}
+	return v, nil
+}
+
+// String accepts strings, numbers and bools
+func (p *Path) String(doc interface{}) (string, error) {
+	v, err := p.first(doc)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", &CoercionError{Path: p.expr, Value: v, To: "string"}
+}
+
+// Int accepts integral numbers and strings holding them, "17" and "17.0" are both 17
+func (p *Path) Int(doc interface{}) (int64, error) {
+	v, err := p.first(doc)
+	if err != nil {
+		return 0, err
+	}
+	switch n := v.(type) {
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+			return int64(n), nil
+		}
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		if f, err := n.Float64(); err == nil && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f), nil
+		}
+	case string:
+		s := strings.TrimSpace(n)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f), nil
+		}
+	}
+	return 0, &CoercionError{Path: p.expr, Value: v, To: "int"}
+}
+
+// Float accepts numbers and numeric strings
+func (p *Path) Float(doc interface{}) (float64, error) {
+	v, err := p.first(doc)
+	if err != nil {
+		return 0, err
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case json.Number:
+		if f, err := n.Float64(); err == nil {
+			return f, nil
+		}
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(n), 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, &CoercionError{Path: p.expr, Value: v, To: "float"}
+}
+
+// Bool accepts bools, "true"/"false"/"1"/"0" and the numbers 0 and 1
+func (p *Path) Bool(doc interface{}) (bool, error) {
+	v, err := p.first(doc)
+	if err != nil {
+		return false, err
+	}
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(b)); err == nil {
+			return parsed, nil
+		}
+	case float64:
+		if b == 0 || b == 1 {
+			return b == 1, nil
+		}
+	case json.Number:
+		if s := b.String(); s == "0" || s == "1" {
+			return s == "1", nil
+		}
+	}
+	return false, &CoercionError{Path: p.expr, Value: v, To: "bool"}
+}
+
+// Find is a shortcut for Compile(expr).Find(doc)
+func Find(doc interface{}, expr string) ([]interface{}, error) {
+	p, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return p.Find(doc), nil
+}
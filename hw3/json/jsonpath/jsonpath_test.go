@@ -0,0 +1,97 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var jsonStr = `{"users": [
+	{"id": 17, "username": "iivan", "phone": 0, "active": "1"},
+	{"id": "17", "address": "none", "company": "Mail.ru", "phone": null, "active": true},
+	{"id": 17.5, "profile": {"id": "42", "tags": ["go", "json"]}}
+]}`
+
+func decode(t *testing.T) interface{} {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(jsonStr), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestFind(t *testing.T) {
+	doc := decode(t)
+	cases := []struct {
+		Expr     string
+		Expected []interface{}
+	}{
+		{"$.users[0].username", []interface{}{"iivan"}},
+		{"users[1]['company']", []interface{}{"Mail.ru"}},
+		{"$.users[-1].profile.tags[*]", []interface{}{"go", "json"}},
+		{"$.users[*].id", []interface{}{17.0, "17", 17.5}},
+		{"$..id", []interface{}{17.0, "17", 17.5, "42"}},
+		{"$.users[5].id", []interface{}{}},
+		{"$.missing", []interface{}{}},
+	}
+	for _, item := range cases {
+		found, err := Find(doc, item.Expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", item.Expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(found, item.Expected) {
+			t.Errorf("%s: got %#v, expected %#v", item.Expr, found, item.Expected)
+		}
+	}
+}
+
+func TestCoercion(t *testing.T) {
+	doc := decode(t)
+
+	for _, expr := range []string{"$.users[0].id", "$.users[1].id", "$.users[2].profile.id"} {
+		if _, err := MustCompile(expr).Int(doc); err != nil {
+			t.Errorf("%s: %s", expr, err)
+		}
+	}
+
+	if _, err := MustCompile("$.users[2].id").Int(doc); !isCoercion(err) {
+		t.Errorf("17.5 must not be an int, got %v", err)
+	}
+	if s, err := MustCompile("$.users[0].id").String(doc); err != nil || s != "17" {
+		t.Errorf("expected \"17\", got %q %v", s, err)
+	}
+	if f, err := MustCompile("$.users[1].id").Float(doc); err != nil || f != 17 {
+		t.Errorf("expected 17.0, got %v %v", f, err)
+	}
+	for _, expr := range []string{"$.users[0].active", "$.users[1].active"} {
+		if b, err := MustCompile(expr).Bool(doc); err != nil || !b {
+			t.Errorf("%s: expected true, got %v %v", expr, b, err)
+		}
+	}
+	if _, err := MustCompile("$.users[1].phone").Int(doc); !errors.Is(err, ErrNull) {
+		t.Errorf("expected ErrNull, got %v", err)
+	}
+	if _, err := MustCompile("$.users[0].address").String(doc); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := MustCompile("$.users").String(doc); !isCoercion(err) {
+		t.Errorf("expected CoercionError for array, got %v", err)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, expr := range []string{"$.users[", "$.users[abc]", "$.", "$..", "$users"} {
+		_, err := Compile(expr)
+		syntaxErr := &SyntaxError{}
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: expected SyntaxError, got %v", expr, err)
+		}
+	}
+}
+
+func isCoercion(err error) bool {
+	coercionErr := &CoercionError{}
+	return errors.As(err, &coercionErr)
+}
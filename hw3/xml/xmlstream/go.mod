@@ -0,0 +1,3 @@
+module xmlstream
+
+go 1.16
@@ -0,0 +1,235 @@
+// Package xmlstream decodes repeated elements of a large XML document one at a time,
+// the way CountDecoder in ../main.go walks tokens, but into whole structs:
+//
+//	ex := xmlstream.New(file, "users/user")
+//	u := User{}
+//	err := ex.Each(&u, func() error {
+//		fmt.Println(u.Login)
+//		return nil
+//	})
+//
+// Only the current record is kept in memory. A record that fails to decode is
+// reported as *RecordError with its line and doesn't stop the stream.
+package xmlstream
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// RecordError is a record that failed to decode, Next can go on after it
+type RecordError struct {
+	Path    string
+	Record  int // 0-based number of the record among the matched ones
+	Line    int // where the record starts
+	Column  int
+	ErrLine int // where the decoder stopped: the failing element or attribute
+	Err     error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("xmlstream: %s #%d at line %d: %s (line %d)", e.Path, e.Record, e.Line, e.Err, e.ErrLine)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+type Extractor struct {
+	decoder *xml.Decoder
+	lines   *lineCounter
+	path    []string
+	stack   []string
+	records int
+}
+
+// New extracts elements at path, a slash separated list of element names from the
+// root: "users/user". A "*" segment matches any element.
+func New(r io.Reader, path string) *Extractor {
+	lines := &lineCounter{r: r}
+	return &Extractor{
+		decoder: xml.NewDecoder(lines),
+		lines:   lines,
+		path:    strings.Split(strings.Trim(path, "/"), "/"),
+	}
+}
+
+// Decoder gives access to the underlying decoder, e.g. to set Strict or CharsetReader
+func (e *Extractor) Decoder() *xml.Decoder {
+	return e.decoder
+}
+
+// Next decodes the next matching element into v as xml.Unmarshal would.
+// It returns io.EOF after the last one. Errors other than *RecordError are fatal.
+func (e *Extractor) Next(v interface{}) error {
+	for {
+		offset := e.decoder.InputOffset()
+		// asked for every token so the counter doesn't keep the newlines of the whole input
+		line, column := e.lines.position(offset)
+		tok, err := e.decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			e.stack = append(e.stack, tok.Name.Local)
+			if !e.matches() {
+				continue
+			}
+			e.stack = e.stack[:len(e.stack)-1] // readRecord consumes the end element
+
+			// the record is read first so a bad value can't leave the stream in the middle of it
+			record := &recordTokens{}
+			record.add(xml.CopyToken(tok), offset)
+			if err := e.readRecord(record); err != nil {
+				return err
+			}
+
+			index := e.records
+			e.records++
+			if err := xml.NewTokenDecoder(record).Decode(v); err != nil {
+				errLine, _ := e.lines.position(record.offsets[record.next-1])
+				return &RecordError{
+					Path:    strings.Join(e.path, "/"),
+					Record:  index,
+					Line:    line,
+					Column:  column,
+					ErrLine: errLine,
+					Err:     err,
+				}
+			}
+			return nil
+
+		case xml.EndElement:
+			if len(e.stack) > 0 {
+				e.stack = e.stack[:len(e.stack)-1]
+			}
+		}
+	}
+}
+
+// readRecord collects the tokens up to the end of the record started in r
+func (e *Extractor) readRecord(r *recordTokens) error {
+	for depth := 1; depth > 0; {
+		offset := e.decoder.InputOffset()
+		tok, err := e.decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+		r.add(xml.CopyToken(tok), offset)
+	}
+	return nil
+}
+
+// recordTokens replays one record for xml.NewTokenDecoder
+type recordTokens struct {
+	tokens  []xml.Token
+	offsets []int64
+	next    int
+}
+
+func (r *recordTokens) add(tok xml.Token, offset int64) {
+	r.tokens = append(r.tokens, tok)
+	r.offsets = append(r.offsets, offset)
+}
+
+func (r *recordTokens) Token() (xml.Token, error) {
+	if r.next == len(r.tokens) {
+		return nil, io.EOF
+	}
+	r.next++
+	return r.tokens[r.next-1], nil
+}
+
+func (e *Extractor) matches() bool {
+	if len(e.stack) != len(e.path) {
+		return false
+	}
+	for i, name := range e.path {
+		if name != "*" && name != e.stack[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Each calls fn after every record decoded into v, v is reset to its zero value
+// before each one. It stops at the first error, including the ones returned by fn,
+// the end of the input is not an error.
+func (e *Extractor) Each(v interface{}, fn func() error) error {
+	return e.each(v, fn, nil)
+}
+
+// EachSkipping is Each that passes record errors to onError and goes on
+func (e *Extractor) EachSkipping(v interface{}, fn func() error, onError func(*RecordError)) error {
+	return e.each(v, fn, onError)
+}
+
+func (e *Extractor) each(v interface{}, fn func() error, onError func(*RecordError)) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return fmt.Errorf("xmlstream: Each(%T): not a pointer", v)
+	}
+	zero := reflect.Zero(val.Elem().Type())
+
+	for {
+		val.Elem().Set(zero)
+		err := e.Next(v)
+		if err == io.EOF {
+			return nil
+		}
+		if recordErr, ok := err.(*RecordError); ok && onError != nil {
+			onError(recordErr)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+}
+
+// lineCounter remembers where the lines start in the part of the input the decoder
+// has read but not yet reported, offsets are asked for in increasing order
+type lineCounter struct {
+	r         io.Reader
+	read      int64
+	line      int
+	lineStart int64
+	newlines  []int64
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			c.newlines = append(c.newlines, c.read+int64(i))
+		}
+	}
+	c.read += int64(n)
+	return n, err
+}
+
+// position returns 1-based line and column of offset
+func (c *lineCounter) position(offset int64) (int, int) {
+	i := 0
+	for i < len(c.newlines) && c.newlines[i] < offset {
+		c.line++
+		c.lineStart = c.newlines[i] + 1
+		i++
+	}
+	c.newlines = append(c.newlines[:0], c.newlines[i:]...)
+	return c.line + 1, int(offset-c.lineStart) + 1
+}
@@ -0,0 +1,144 @@
+package xmlstream
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type User struct {
+	ID      int    `xml:"id,attr"`
+	Login   string `xml:"login"`
+	Name    string `xml:"name"`
+	Browser string `xml:"browser"`
+	Age     int    `xml:"age"`
+}
+
+var usersXML = `<?xml version="1.0" encoding="utf-8"?>
+<users version="1">
+	<user id="1">
+		<login>user1</login>
+		<name>Василий Романов</name>
+	</user>
+	<user id="x2">
+		<login>user2</login>
+	</user>
+	<group>
+		<user id="100"><login>not a direct child</login></user>
+	</group>
+	<user id="3">
+		<login>user3</login>
+		<age>many</age>
+		<browser>Mozilla</browser>
+	</user>
+	<user id="4"><login>user4</login></user>
+</users>`
+
+func TestNext(t *testing.T) {
+	ex := New(strings.NewReader(usersXML), "users/user")
+
+	u := &User{}
+	if err := ex.Next(u); err != nil || u.ID != 1 || u.Login != "user1" || u.Name != "Василий Романов" {
+		t.Fatalf("first record: %#v %v", u, err)
+	}
+
+	cases := []struct {
+		Record  int
+		Line    int
+		ErrLine int
+	}{
+		{1, 7, 7},   // attribute error is reported on the start tag
+		{2, 13, 15}, // element error on the element
+	}
+	for caseNum, item := range cases {
+		err := ex.Next(&User{})
+		recordErr := &RecordError{}
+		if !errors.As(err, &recordErr) {
+			t.Errorf("[%d] expected RecordError, got %v", caseNum, err)
+			continue
+		}
+		numErr := &strconv.NumError{}
+		if !errors.As(err, &numErr) {
+			t.Errorf("[%d] expected a wrapped strconv error, got %v", caseNum, recordErr.Err)
+		}
+		if recordErr.Record != item.Record || recordErr.Line != item.Line || recordErr.ErrLine != item.ErrLine {
+			t.Errorf("[%d] wrong position: record %d lines %d/%d", caseNum, recordErr.Record, recordErr.Line, recordErr.ErrLine)
+		}
+	}
+
+	u = &User{}
+	if err := ex.Next(u); err != nil || u.ID != 4 {
+		t.Errorf("the stream must go on after bad records: %#v %v", u, err)
+	}
+	if err := ex.Next(u); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestEach(t *testing.T) {
+	logins := []string{}
+	skipped := []int{}
+	u := User{}
+	err := New(strings.NewReader(usersXML), "/users/*").EachSkipping(&u, func() error {
+		logins = append(logins, u.Login)
+		return nil
+	}, func(err *RecordError) {
+		skipped = append(skipped, err.Line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// <group> matches the wildcard too and decodes to an empty User
+	if strings.Join(logins, ",") != "user1,,user4" || len(skipped) != 2 {
+		t.Errorf("wrong records: %q, skipped at lines %v", logins, skipped)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = New(strings.NewReader(usersXML), "users/user").Each(&u, func() error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("fn error must stop Each: %v after %d", err, count)
+	}
+
+	err = New(strings.NewReader(usersXML), "users/user").Each(&u, func() error { return nil })
+	if !errors.As(err, new(*RecordError)) {
+		t.Errorf("Each must stop at a bad record, got %v", err)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	ex := New(strings.NewReader("<users>\n<user id=\"1\"><login>a</user>\n</users>"), "users/user")
+	err := ex.Next(&User{})
+	if err == nil || errors.As(err, new(*RecordError)) {
+		t.Errorf("expected a fatal syntax error, got %v", err)
+	}
+
+	ex = New(strings.NewReader("<users>\n<user id=\"1\"><login>a</login>"), "users/user")
+	syntaxErr := &xml.SyntaxError{}
+	if err := ex.Next(&User{}); !errors.As(err, &syntaxErr) || syntaxErr.Line != 2 {
+		t.Errorf("expected a syntax error on line 2 for a cut record, got %v", err)
+	}
+}
+
+func BenchmarkNext(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("<users>\n")
+	for i := 0; i < 1000; i++ {
+		sb.WriteString(`<user id="1"><login>user1</login><name>Name</name><browser>Mozilla</browser></user>` + "\n")
+	}
+	sb.WriteString("</users>")
+	data := sb.String()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ex := New(strings.NewReader(data), "users/user")
+		u := User{}
+		ex.Each(&u, func() error { return nil })
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache - кеш ответов FindUsers с вытеснением давно не использованных.
+// Ответ моложе TTL отдаётся без запроса, более старый перепроверяется
+// на сервере по ETag: если результат не изменился, сервер отвечает 304
+type Cache struct {
+	TTL        time.Duration
+	MaxEntries int // 0 - без ограничения
+
+	mu    sync.Mutex
+	order *list.List // *cacheEntry, недавно использованные в начале
+	items map[string]*list.Element
+	now   func() time.Time // для тестов, nil - time.Now
+}
+
+type cacheEntry struct {
+	key    string
+	resp   SearchResponse
+	etag   string
+	stored time.Time
+}
+
+// response - копия ответа, чтобы вызывающий не испортил кеш
+func (e *cacheEntry) response() *SearchResponse {
+	resp := e.resp
+	resp.Users = append([]User(nil), e.resp.Users...)
+	return &resp
+}
+
+func NewCache(maxEntries int, ttl time.Duration) *Cache {
+	return &Cache{TTL: ttl, MaxEntries: maxEntries}
+}
+
+func (c *Cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// get возвращает запись и свежая ли она. Устаревшая запись без ETag бесполезна и удаляется
+func (c *Cache) get(key string) (*cacheEntry, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.clock().Sub(entry.stored) < c.TTL {
+		c.order.MoveToFront(el)
+		return entry, true
+	}
+	if entry.etag == "" {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	return entry, false
+}
+
+// put сохраняет копию ответа, заново отсчитывая TTL
+func (c *Cache) put(key string, resp *SearchResponse, etag string) {
+	if c == nil {
+		return
+	}
+	entry := &cacheEntry{key: key, resp: *resp, etag: etag}
+	entry.resp.Users = append([]User(nil), resp.Users...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.items == nil {
+		c.order = list.New()
+		c.items = make(map[string]*list.Element)
+	}
+	entry.stored = c.clock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	for c.MaxEntries > 0 && len(c.items) > c.MaxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
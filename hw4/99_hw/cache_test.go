@@ -0,0 +1,128 @@
+package main
+
+import (
+	"hw4/searchserver"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	data, err := ioutil.ReadFile("dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	ioutil.WriteFile(path, data, 0644)
+	srv, err := searchserver.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Dataset().ReloadInterval = 0
+
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	cache := NewCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+	metrics := &Metrics{}
+	u := &SearchClient{URL: ts.URL, AccessToken: "token", Cache: cache, Metrics: metrics}
+
+	req := SearchRequest{Limit: 2, OrderField: "Id", OrderBy: OrderByAsc}
+	first, err := u.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Users[0].Name = "changed by the caller"
+
+	// fresh: no request at all
+	res, err := u.FindUsers(req)
+	if err != nil || calls != 1 || res.Users[0].Name != "Boyd Wolf" || !res.NextPage {
+		t.Fatalf("expected a cached copy, got %+v %v after %d calls", res, err, calls)
+	}
+
+	// another token is another entry
+	u.AccessToken = "other"
+	u.FindUsers(req)
+	if calls != 2 {
+		t.Errorf("token must be a part of the key, %d calls", calls)
+	}
+	u.AccessToken = "token"
+
+	// stale: revalidated, the server answers 304
+	now = now.Add(time.Minute)
+	res, err = u.FindUsers(req)
+	if err != nil || calls != 3 || len(res.Users) != 2 {
+		t.Fatalf("expected a revalidated answer, got %+v %v after %d calls", res, err, calls)
+	}
+	u.FindUsers(req)
+	if calls != 3 {
+		t.Errorf("revalidation must restart the TTL, %d calls", calls)
+	}
+
+	// the dataset changes: stale entries get the new answer
+	ioutil.WriteFile(path, []byte(`<root><row><id>7</id><first_name>New</first_name></row></root>`), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	now = now.Add(time.Minute)
+	res, err = u.FindUsers(req)
+	if err != nil || len(res.Users) != 1 || res.Users[0].Id != 7 || res.NextPage {
+		t.Errorf("expected the new dataset, got %+v %v", res, err)
+	}
+
+	// the least recently used entry goes first
+	u.FindUsers(SearchRequest{Limit: 3})
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.Len())
+	}
+	calls = 0
+	u.FindUsers(req)
+	u.AccessToken = "other"
+	u.FindUsers(req)
+	if calls != 1 {
+		t.Errorf("expected only the other token to be evicted, %d calls", calls)
+	}
+
+	expected := MetricsSnapshot{Requests: 6, CacheHits: 3, NotModified: 1}
+	if got := metrics.Snapshot(); got != expected {
+		t.Errorf("got metrics %+v, expected %+v", got, expected)
+	}
+}
+
+func TestCacheWithoutETag(t *testing.T) {
+	var calls int64
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt64(&calls, 1)
+		if r.Header.Get("If-None-Match") != "" {
+			t.Errorf("no ETag was given")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`[{"Id":1}]`)),
+			Header:     http.Header{},
+		}, nil
+	})
+
+	now := time.Now()
+	cache := NewCache(0, time.Minute)
+	cache.now = func() time.Time { return now }
+	u := &SearchClient{URL: "http://search.invalid/", Transport: transport, Cache: cache}
+	u.FindUsers(SearchRequest{})
+	u.FindUsers(SearchRequest{})
+	now = now.Add(time.Minute)
+	u.FindUsers(SearchRequest{})
+	if calls != 2 {
+		t.Errorf("expected a request per TTL, got %d", calls)
+	}
+}
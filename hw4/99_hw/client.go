@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,15 +25,22 @@ type User struct {
 	Age    int
 	About  string
 	Gender string
+
+	// заполняются, если в Query есть слова для полнотекстового поиска
+	Score   float64 // релевантность
+	Snippet string  // HTML: найденные слова обёрнуты в <em></em>, остальное экранировано
 }
 
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// NextCursor - SearchRequest.Cursor следующей страницы, пустой на последней
+	NextCursor string
 }
 
 type SearchErrorResponse struct {
-	Error string
+	Error  string
+	Detail string // ошибка разбора query, order_field или fields с позицией
 }
 
 const (
@@ -40,14 +49,25 @@ const (
 	OrderByDesc = 1
 
 	ErrorBadOrderField = `OrderField invalid`
+
+	// MaxLimit - больше пользователей за один запрос FindUsers не вернёт
+	MaxLimit = 25
+
+	// NextCursorHeader - хедер ответа с курсором следующей страницы
+	NextCursorHeader = "X-Next-Cursor"
 )
 
+// Query, OrderField и Fields пишутся на языке пакета hw4/query
 type SearchRequest struct {
 	Limit      int
 	Offset     int    // Можно учесть после сортировки
-	Query      string // подстрока в 1 из полей
-	OrderField string
+	Query      string // условия вида age>=30 gender:female about~"golang", слова без поля ищутся полнотекстовым поиском
+	OrderField string // одно или несколько полей, "-Age,Name", направление без знака задаёт OrderBy, Relevance - сначала лучшие
 	OrderBy    int
+	Fields     []string // какие поля вернуть, пустой - все
+	// Cursor - NextCursor предыдущей страницы, Query и порядок должны быть те же.
+	// В отличие от Offset страницы не съезжают, если данные на сервере поменялись
+	Cursor string
 }
 
 type SearchClient struct {
@@ -55,75 +75,278 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// HTTPClient, если задан, используется вместо общего клиента с таймаутом в секунду
+	HTTPClient *http.Client
+	// Transport подменяет транспорт общего клиента, таймаут остаётся прежним
+	Transport http.RoundTripper
+	// Retry - повторы при таймаутах и 5xx, nil - без повторов
+	Retry *RetryPolicy
+	// Breaker может быть общим для нескольких клиентов одного сервера, nil - без него
+	Breaker *CircuitBreaker
+	// Metrics считает запросы, повторы и срабатывания Breaker, nil - не считать
+	Metrics *Metrics
+	// Cache хранит ответы по запросу и токену, nil - без кеша
+	Cache *Cache
+	// LegacyPaging - для серверов без X-Next-Cursor: запрашивается на одного
+	// пользователя больше, по нему определяется NextPage. Курсоров при этом нет
+	LegacyPaging bool
+}
+
+// Ошибки FindUsers, проверяются через errors.Is. Текст самой ошибки остаётся прежним
+var (
+	ErrInvalidRequest = errors.New("invalid search request")
+	ErrTimeout        = errors.New("search timeout")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrBadOrderField  = errors.New("bad order field")
+	ErrBadRequest     = errors.New("bad search request")
+	ErrServer         = errors.New("search server error")
+	ErrBadResponse    = errors.New("bad search response")
+	ErrUnavailable    = errors.New("search server unavailable")
+	ErrCircuitOpen    = errors.New("circuit breaker is open")
+	ErrBadQuery       = errors.New("bad query")
+	ErrBadCursor      = errors.New("bad cursor")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrForbidden      = errors.New("forbidden")
+	ErrRateLimited    = errors.New("rate limited")
+)
+
+// searchError хранит вид ошибки для errors.Is и сообщение, которое видит пользователь
+type searchError struct {
+	kind error
+	msg  string
+}
+
+func newSearchError(kind error, format string, args ...interface{}) error {
+	return &searchError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *searchError) Error() string {
+	return e.msg
+}
+
+func (e *searchError) Unwrap() error {
+	return e.kind
+}
+
+// RateLimitError - ответ 429, errors.Is(err, ErrRateLimited) для него тоже верно
+type RateLimitError struct {
+	RetryAfter time.Duration // когда сервер снова примет запрос, 0 - не сказал
+	Detail     string
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s: %s", e.RetryAfter, e.Detail)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext - FindUsers, который можно отменить или ограничить по времени через ctx.
+// Если заданы Retry и Breaker, временные ошибки повторяются, а при падающем сервере
+// запросы сразу завершаются с ErrCircuitOpen. Отмена ctx в паузе между повторами
+// возвращает ошибку ctx, errors.Is верно и для ошибки последней попытки.
+// С Cache свежий ответ не запрашивается повторно, а устаревший перепроверяется
+// через If-None-Match
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+	params, err := searchParams(req, srv.LegacyPaging)
+	if err != nil {
+		return nil, err
+	}
+
+	key := srv.AccessToken + "\n" + params.Encode()
+	cached, fresh := srv.Cache.get(key)
+	if fresh {
+		srv.Metrics.add(metricCacheHits)
+		return cached.response(), nil
+	}
+	etag := ""
+	if cached != nil {
+		etag = cached.etag
+	}
+
+	for attempt := 1; ; attempt++ {
+		probe, err := srv.Breaker.allow()
+		if err != nil {
+			srv.Metrics.add(metricRejected)
+			return nil, err
+		}
+		srv.Metrics.add(metricRequests)
+		resp, newETag, err := srv.findUsers(ctx, req, params, etag)
+		if srv.Breaker.record(probe, err) {
+			srv.Metrics.add(metricBreakerOpened)
+		}
+		if err == errNotModified {
+			srv.Metrics.add(metricNotModified)
+			srv.Cache.put(key, &cached.resp, etag)
+			return cached.response(), nil
+		}
+		if err == nil {
+			srv.Cache.put(key, resp, newETag)
+			return resp, nil
+		}
+		srv.Metrics.add(metricFailures)
+
+		if !isTransient(err) || attempt >= srv.Retry.attempts() {
+			return nil, err
+		}
+		timer := time.NewTimer(srv.Retry.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &retryCanceled{ctx: ctx.Err(), last: err}
+		}
+		srv.Metrics.add(metricRetries)
+	}
+}
 
+// searchParams проверяет запрос и переводит его в параметры урла,
+// с probe просит на одного пользователя больше
+func searchParams(req SearchRequest, probe bool) (url.Values, error) {
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
-		return nil, fmt.Errorf("limit must be > 0")
+		return nil, newSearchError(ErrInvalidRequest, "limit must be > 0")
 	}
-	if req.Limit > 25 {
-		req.Limit = 25
+	if req.Limit > MaxLimit {
+		req.Limit = MaxLimit
 	}
 	if req.Offset < 0 {
-		return nil, fmt.Errorf("offset must be > 0")
+		return nil, newSearchError(ErrInvalidRequest, "offset must be > 0")
+	}
+	if probe {
+		req.Limit++
 	}
-
-	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	if len(req.Fields) > 0 {
+		searcherParams.Add("fields", strings.Join(req.Fields, ","))
+	}
+	if req.Cursor != "" {
+		searcherParams.Add("cursor", req.Cursor)
+	}
+	return searcherParams, nil
+}
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
+// errNotModified - ответ 304 на запрос с etag, результат берётся из кеша
+var errNotModified = errors.New("not modified")
+
+// findUsers - одна попытка запроса, etag - ETag закешированного ответа, если он есть.
+// Возвращает ETag нового ответа
+func (srv *SearchClient) findUsers(ctx context.Context, req SearchRequest, searcherParams url.Values, etag string) (*SearchResponse, string, error) {
+	searcherReq, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	if err != nil {
+		return nil, "", newSearchError(ErrInvalidRequest, "bad request %s", err)
+	}
 	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	if etag != "" {
+		searcherReq.Header.Set("If-None-Match", etag)
+	}
 
-	resp, err := client.Do(searcherReq)
+	resp, err := srv.httpClient().Do(searcherReq)
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+		if ctx.Err() == context.Canceled {
+			return nil, "", newSearchError(context.Canceled, "search canceled for %s", searcherParams.Encode())
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		if err, ok := err.(net.Error); ok && err.Timeout() || ctx.Err() == context.DeadlineExceeded {
+			return nil, "", newSearchError(ErrTimeout, "timeout for %s", searcherParams.Encode())
+		}
+		return nil, "", newSearchError(ErrUnavailable, "unknown error %s", err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", newSearchError(ErrUnavailable, "cant read response: %s", err)
+	}
 
 	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if etag == "" {
+			return nil, "", newSearchError(ErrBadResponse, "unexpected %s", resp.Status)
+		}
+		return nil, "", errNotModified
 	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
+		// тело с причиной есть не у всех серверов, без него это просто плохой токен
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(body, &errResp)
+		if errResp.Error == "ErrorTokenExpired" {
+			return nil, "", newSearchError(ErrTokenExpired, "AccessToken expired: %s", errResp.Detail)
+		}
+		return nil, "", newSearchError(ErrUnauthorized, "Bad AccessToken")
+	case http.StatusForbidden:
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(body, &errResp)
+		return nil, "", newSearchError(ErrForbidden, "AccessToken forbidden: %s", errResp.Detail)
+	case http.StatusTooManyRequests:
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(body, &errResp)
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, "", &RateLimitError{RetryAfter: time.Duration(seconds) * time.Second, Detail: errResp.Detail}
 	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
+		return nil, "", newSearchError(ErrServer, "SearchServer fatal error")
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
+			return nil, "", newSearchError(ErrBadResponse, "cant unpack error json: %s", err)
 		}
-		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+		switch errResp.Error {
+		case "ErrorBadOrderField":
+			return nil, "", newSearchError(ErrBadOrderField, "OrderFeld %s invalid", req.OrderField)
+		case "ErrorBadQuery":
+			return nil, "", newSearchError(ErrBadQuery, "Query %q invalid: %s", req.Query, errResp.Detail)
+		case "ErrorBadCursor":
+			return nil, "", newSearchError(ErrBadCursor, "Cursor invalid: %s", errResp.Detail)
+		case "ErrorBadFields":
+			return nil, "", newSearchError(ErrBadQuery, "Fields %s invalid: %s", strings.Join(req.Fields, ","), errResp.Detail)
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		return nil, "", newSearchError(ErrBadRequest, "unknown bad request error: %s", errResp.Error)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, "", newSearchError(ErrServer, "SearchServer error: %s", resp.Status)
 	}
 
 	data := []User{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
+		return nil, "", newSearchError(ErrBadResponse, "cant unpack result json: %s", err)
 	}
 
-	result := SearchResponse{}
-	if len(data) == req.Limit {
+	// есть ли следующая страница, сервер сообщает курсором на неё
+	result := SearchResponse{Users: data, NextCursor: resp.Header.Get(NextCursorHeader)}
+	result.NextPage = result.NextCursor != ""
+
+	// лишние пользователи - ответ на запрос с probe или сервер, который не
+	// смотрит на limit. Курсор такого ответа указывает не туда
+	limit := req.Limit
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	if len(result.Users) > limit {
+		result.Users = result.Users[:limit]
 		result.NextPage = true
-		result.Users = data[0 : len(data)-1]
-	} else {
-		result.Users = data[0:len(data)]
+		result.NextCursor = ""
 	}
 
-	return &result, err
+	return &result, resp.Header.Get("ETag"), nil
+}
+
+func (srv *SearchClient) httpClient() *http.Client {
+	if srv.HTTPClient != nil {
+		return srv.HTTPClient
+	}
+	if srv.Transport != nil {
+		return &http.Client{Timeout: client.Timeout, Transport: srv.Transport}
+	}
+	return client
 }
@@ -1,45 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"fmt"
+	"hw4/searchserver"
+	"hw4/searchtest"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"sort"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
-type XmlRoot struct {
-	XmlName xml.Name  `xml:"root"`
-	Users   []XmlUser `xml:"row"`
-}
-
-type XmlUser struct {
-	XmlName       xml.Name `xml:"row"`
-	Id            int      `xml:"id"`
-	Guid          string   `xml:"guid"`
-	IsActive      bool     `xml:"isActive"`
-	Balance       string   `xml:"balance"`
-	Picture       string   `xml:"picture"`
-	Age           int      `xml:"age"`
-	EyeColor      string   `xml:"eyeColor"`
-	FirstName     string   `xml:"first_name"`
-	LastName      string   `xml:"last_name"`
-	Gender        string   `xml:"gender"`
-	Company       string   `xml:"company"`
-	Email         string   `xml:"email"`
-	Phone         string   `xml:"phone"`
-	Address       string   `xml:"address"`
-	About         string   `xml:"about"`
-	Registered    string   `xml:"registered"`
-	FavoriteFruit string   `xml:"favoriteFruit"`
-}
-
 type UserApi struct {
 	UserApiURL string
 }
@@ -53,6 +31,22 @@ type UserTestCase struct {
 	CheckResult func(tk UserTestCase) bool
 }
 
+// newTestServer поднимает searchserver на dataset.xml, handler, если задан,
+// вызывается перед ним на каждый запрос
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *searchserver.Server) {
+	srv, err := searchserver.New("dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler != nil {
+			handler(w, r)
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	return ts, srv
+}
+
 func TestFindUsers(t *testing.T) {
 	cases := []UserTestCase{
 		{
@@ -103,6 +97,18 @@ func TestFindUsers(t *testing.T) {
 				return len(tk.Result.Users) == 25
 			},
 		},
+		{
+			Request: SearchRequest{
+				Query:  "",
+				Limit:  0,
+				Offset: 0,
+			},
+			Token:   "token",
+			IsError: false,
+			CheckResult: func(tk UserTestCase) bool {
+				return len(tk.Result.Users) == 0
+			},
+		},
 		{
 			Request: SearchRequest{
 				Query:  "",
@@ -142,7 +148,7 @@ func TestFindUsers(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	ts, _ := newTestServer(t, nil)
 
 	for caseNum, item := range cases {
 		u := &SearchClient{
@@ -172,376 +178,339 @@ func TestFindUsers(t *testing.T) {
 	ts.Close()
 
 	testTimeout(t)
-	testInternalError(t)
-	testError(t)
 	testBadRequest(t)
-	testBrokenJsonWithBadRequest(t)
-	testBrokenJsonWithOkRequest(t)
 }
 
-func testBrokenJsonWithBadRequest(t *testing.T) {
-	tsBadJsonRequest := httptest.NewServer(http.HandlerFunc(ServeBrokenJson))
-	u := &SearchClient{
-		URL:         tsBadJsonRequest.URL,
-		AccessToken: "TOKEN",
-	}
-	_, err := u.FindUsers(SearchRequest{
-		Limit:      10,
-		Offset:     0,
-		Query:      "",
-		OrderField: "",
-		OrderBy:    0,
-	})
-
-	if err == nil {
-		t.Errorf("Expected bad json request, got nil")
-	}
-}
-
-func testBrokenJsonWithOkRequest(t *testing.T) {
-	tsBadJsonRequest := httptest.NewServer(http.HandlerFunc(ServeBrokenJsonOkResult))
+// testTimeout checks the default timeout of the client, without a context
+func testTimeout(t *testing.T) {
+	ts := searchtest.NewServer(nil).Enqueue(searchtest.Slow(5*time.Second, searchtest.JSON(http.StatusOK, []User{})))
+	defer ts.Close()
 	u := &SearchClient{
-		URL:         tsBadJsonRequest.URL,
+		URL:         ts.URL,
 		AccessToken: "TOKEN",
 	}
-	_, err := u.FindUsers(SearchRequest{
-		Limit:      10,
-		Offset:     0,
-		Query:      "",
-		OrderField: "",
-		OrderBy:    0,
-	})
+	_, err := u.FindUsers(SearchRequest{Limit: 10})
 
-	if err == nil {
-		t.Errorf("Expected bad json request, got nil")
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected timeout error, got %v", err)
 	}
 }
 
 func testBadRequest(t *testing.T) {
-	tsBadRequest := httptest.NewServer(http.HandlerFunc(ServeBadRequest))
+	ts := searchtest.NewServer(nil).Enqueue(searchtest.JSON(http.StatusBadRequest, SearchErrorResponse{Error: "OUCH"}))
+	defer ts.Close()
 	u := &SearchClient{
-		URL:         tsBadRequest.URL,
+		URL:         ts.URL,
 		AccessToken: "TOKEN",
 	}
-	_, err := u.FindUsers(SearchRequest{
-		Limit:      10,
-		Offset:     0,
-		Query:      "",
-		OrderField: "",
-		OrderBy:    0,
-	})
+	_, err := u.FindUsers(SearchRequest{Limit: 10})
 
-	if err == nil {
-		t.Errorf("Expected bad request, got nil")
+	if err == nil || err.Error() != "unknown bad request error: OUCH" {
+		t.Errorf("Expected bad request, got %v", err)
 	}
-
-	if err != nil && err.Error() != "unknown bad request error: OUCH" {
-		t.Errorf("Expected bad request, got %s", err)
+	if !errors.Is(err, ErrBadRequest) {
+		t.Errorf("Expected ErrBadRequest, got %v", err)
 	}
 }
 
-func testInternalError(t *testing.T) {
-	tsInternalError := httptest.NewServer(http.HandlerFunc(ServeInternalError))
-	u := &SearchClient{
-		URL:         tsInternalError.URL,
-		AccessToken: "TOKEN",
-	}
-	_, err := u.FindUsers(SearchRequest{
-		Limit:      10,
-		Offset:     0,
-		Query:      "",
-		OrderField: "",
-		OrderBy:    0,
-	})
-
-	if err == nil {
-		t.Errorf("Expected error, got nil")
-	}
+// conformanceClient runs SearchClient in searchtest.RunClientSuite
+type conformanceClient struct {
+	*SearchClient
 }
 
-func testError(t *testing.T) {
-	tsError := httptest.NewServer(http.HandlerFunc(ServeError))
-	u := &SearchClient{
-		URL:         tsError.URL,
-		AccessToken: "TOKEN",
-	}
-	_, err := u.FindUsers(SearchRequest{
-		Limit:      10,
-		Offset:     0,
-		Query:      "",
-		OrderField: "",
-		OrderBy:    0,
-	})
-
-	if err == nil {
-		t.Errorf("Expected error, got nil")
-	}
+// conformanceErrors map the errors of the client to the kinds of searchtest
+var conformanceErrors = []struct {
+	err, kind error
+}{
+	{ErrUnauthorized, searchtest.ErrUnauthorized},
+	{ErrBadOrderField, searchtest.ErrBadOrderField},
+	{ErrBadRequest, searchtest.ErrBadRequest},
+	{ErrServer, searchtest.ErrServer},
+	{ErrBadResponse, searchtest.ErrBadResponse},
+	{ErrTimeout, searchtest.ErrTimeout},
+	{ErrUnavailable, searchtest.ErrUnavailable},
 }
 
-func testTimeout(t *testing.T) {
-	tsTimeoutError := httptest.NewServer(http.HandlerFunc(ServeTimeout))
-	u := &SearchClient{
-		URL:         tsTimeoutError.URL,
-		AccessToken: "TOKEN",
-	}
-	_, err := u.FindUsers(SearchRequest{
-		Limit:      10,
-		Offset:     0,
-		Query:      "",
-		OrderField: "",
-		OrderBy:    0,
+func (c conformanceClient) FindUsers(ctx context.Context, req searchtest.Request) (*searchtest.Result, error) {
+	res, err := c.FindUsersContext(ctx, SearchRequest{
+		Limit:      req.Limit,
+		Offset:     req.Offset,
+		Query:      req.Query,
+		OrderField: req.OrderField,
+		OrderBy:    req.OrderBy,
 	})
-
-	if err == nil {
-		t.Errorf("Expected timeout error, got nil")
+	if err != nil {
+		for _, e := range conformanceErrors {
+			if errors.Is(err, e.err) {
+				return nil, fmt.Errorf("%w: %s", e.kind, err)
+			}
+		}
+		return nil, err
 	}
-}
 
-func ServeTimeout(w http.ResponseWriter, r *http.Request) {
-	time.Sleep(5 * time.Second)
-	w.WriteHeader(http.StatusOK)
+	result := &searchtest.Result{NextPage: res.NextPage}
+	for _, u := range res.Users {
+		result.Users = append(result.Users, searchtest.User{Id: u.Id, Name: u.Name, Age: u.Age, About: u.About, Gender: u.Gender})
+	}
+	return result, nil
 }
 
-func ServeError(w http.ResponseWriter, r *http.Request) {
-	panic("ERROR")
+func TestConformance(t *testing.T) {
+	searchtest.RunClientSuite(t, func(url string) searchtest.Client {
+		return conformanceClient{&SearchClient{URL: url, AccessToken: "token"}}
+	})
 }
 
-func ServeInternalError(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusInternalServerError)
-}
+func TestFindUsersContext(t *testing.T) {
+	ts, _ := newTestServer(t, nil)
+	defer ts.Close()
 
-func ServeBrokenJson(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte("_________brooooooken"))
-}
+	u := &SearchClient{URL: ts.URL}
+	if _, err := u.FindUsers(SearchRequest{}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized, got %v", err)
+	}
+	u.AccessToken = "token"
+	if _, err := u.FindUsers(SearchRequest{OrderField: "BAD"}); !errors.Is(err, ErrBadOrderField) {
+		t.Errorf("Expected ErrBadOrderField, got %v", err)
+	}
+	if _, err := u.FindUsers(SearchRequest{Limit: -1}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected ErrInvalidRequest, got %v", err)
+	}
 
-func ServeBrokenJsonOkResult(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("_________brooooooken"))
-}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := u.FindUsersContext(ctx, SearchRequest{Limit: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
 
-func ServeBadRequest(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusBadRequest)
-	resp := SearchErrorResponse{
-		Error: "OUCH",
+	// the server stops waiting when the client gives up, so it closes at once
+	blocking := searchtest.NewServer(nil).Enqueue(searchtest.Slow(time.Hour, searchtest.JSON(http.StatusOK, []User{})))
+	defer blocking.Close()
+	u.URL = blocking.URL
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := u.FindUsersContext(ctx, SearchRequest{Limit: 1}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("deadline of the context was ignored, took %s", elapsed)
 	}
-	j, _ := json.Marshal(resp)
-	w.Write(j)
 }
 
-func SearchServer(w http.ResponseWriter, r *http.Request) {
-	limit := r.FormValue("limit")
-	offset := r.FormValue("offset")
-	query := r.FormValue("query")
-	orderField := r.FormValue("order_field")
-	orderBy := r.FormValue("order_by")
-
-	token := r.Header.Get("AccessToken")
-
-	if token == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+func TestFindUsersQuery(t *testing.T) {
+	ts, _ := newTestServer(t, nil)
+	defer ts.Close()
+	u := &SearchClient{URL: ts.URL, AccessToken: "token"}
+
+	res, err := u.FindUsers(SearchRequest{
+		Limit:      25,
+		Query:      "age>=30 gender:female",
+		OrderField: "-Age,Id",
+		OrderBy:    OrderByAsc,
+		Fields:     []string{"Id", "Age"},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	rq := parseParams(limit, offset, query, orderField, orderBy)
-
-	if err := validateParams(rq.OrderField, rq.OrderBy); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		errorBody := SearchErrorResponse{
-			Error: err.Error(),
+	if len(res.Users) == 0 {
+		t.Fatalf("expected users")
+	}
+	for i, user := range res.Users {
+		if user.Age < 30 || user.Name != "" || user.Gender != "" {
+			t.Errorf("unexpected user %+v", user)
+		}
+		if i > 0 && (user.Age > res.Users[i-1].Age || user.Age == res.Users[i-1].Age && user.Id < res.Users[i-1].Id) {
+			t.Errorf("wrong order at %d: %+v", i, res.Users)
 		}
-		j, _ := json.Marshal(errorBody)
-
-		w.Write(j)
-		return
 	}
 
-	users, err := findUsers(rq)
-
+	res, err = u.FindUsers(SearchRequest{Limit: MaxLimit, Query: "NOSTRUD cillum age<30", OrderField: "Relevance"})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		t.Fatal(err)
 	}
-
-	j, err := json.Marshal(users)
-
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	if len(res.Users) == 0 {
+		t.Fatalf("expected full-text matches")
 	}
-
-	w.Write(j)
-}
-
-func findUsers(rq SearchRequest) ([]User, error) {
-	u, err := readUsers("dataset.xml")
-
-	if err != nil {
-		return nil, err
+	for i, user := range res.Users {
+		if user.Score <= 0 || !strings.Contains(strings.ToLower(user.Snippet), "<em>nostrud</em>") && !strings.Contains(user.Snippet, "<em>cillum</em>") {
+			t.Errorf("unexpected user %+v", user)
+		}
+		if i > 0 && user.Score > res.Users[i-1].Score {
+			t.Errorf("wrong order at %d", i)
+		}
 	}
 
-	u = sortUsers(u, rq.OrderField, rq.OrderBy)
-
-	if rq.Offset > len(u)+1 {
-		return make([]User, 0), nil
+	_, err = u.FindUsers(SearchRequest{Query: "age>=thirty"})
+	if !errors.Is(err, ErrBadQuery) || err.Error() != `Query "age>=thirty" invalid: col 6: Age needs a number, got "thirty"` {
+		t.Errorf("expected ErrBadQuery, got %v", err)
+	}
+	_, err = u.FindUsers(SearchRequest{Fields: []string{"Id", "Salary"}})
+	if !errors.Is(err, ErrBadQuery) {
+		t.Errorf("expected ErrBadQuery, got %v", err)
 	}
-
-	u = filterUsers(u, rq.Query)
-	u = trimUsers(u, rq.Offset, rq.Limit)
-
-	return u, nil
 }
 
-func trimUsers(u []User, offset, limit int) []User {
-	start := offset
-	finish := start + limit
+func TestFindUsersCursor(t *testing.T) {
+	ts, _ := newTestServer(t, nil)
+	defer ts.Close()
+	u := &SearchClient{URL: ts.URL, AccessToken: "token"}
 
-	if finish > len(u) {
-		finish = len(u)
+	req := SearchRequest{Limit: 10, Query: "age>=30", OrderField: "-Age"}
+	all, err := u.FindUsers(SearchRequest{Limit: MaxLimit, Query: req.Query, OrderField: req.OrderField})
+	if err != nil || all.NextPage {
+		t.Fatalf("expected all users on one page: %v", err)
 	}
 
-	result := u[start:finish]
-	return result
-}
-
-func filterUsers(u []User, query string) []User {
-	if query == "" {
-		return u
+	got := []User{}
+	for pages := 0; ; pages++ {
+		if pages > len(all.Users) {
+			t.Fatalf("cursors don't advance")
+		}
+		res, err := u.FindUsers(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, res.Users...)
+		if !res.NextPage {
+			break
+		}
+		req.Cursor = res.NextCursor
+	}
+	if !reflect.DeepEqual(got, all.Users) {
+		t.Errorf("pages by cursor differ from a single page:\n%v\n%v", got, all.Users)
 	}
 
-	result := make([]User, 0)
-	for i := 0; i < len(u); i++ {
-		if query != "" && (u[i].Name == query || u[i].About == query) {
-			result = append(result, u[i])
-		}
+	req.Query = "age>=31"
+	if _, err := u.FindUsers(req); !errors.Is(err, ErrBadCursor) {
+		t.Errorf("expected ErrBadCursor for another query, got %v", err)
 	}
-	return result
 }
 
-func sortUsers(u []User, orderFiled string, orderBy int) []User {
-	if orderBy == OrderByAsIs {
-		return u
-	}
-	sort.Slice(u, func(i, j int) bool {
-		switch orderFiled {
-		case "Id":
-			if orderBy == OrderByAsc {
-				return u[i].Id > u[j].Id
-			} else {
-				return u[i].Id < u[j].Id
-			}
-		case "Age":
-			if orderBy == OrderByAsc {
-				return u[i].Age > u[j].Age
-			} else {
-				return u[i].Age < u[j].Age
-			}
-		case "Name":
-			fallthrough
-		default:
-			if orderBy == OrderByAsc {
-				return u[i].Name > u[j].Name
-			} else {
-				return u[i].Name < u[j].Name
-			}
+// legacyServer отвечает как сервер без X-Next-Cursor: ровно limit пользователей со смещения offset.
+// С ignoreLimit - все пользователи
+func legacyServer(total int, ignoreLimit bool) *searchtest.Server {
+	return searchtest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+		offset, _ := strconv.Atoi(r.FormValue("offset"))
+		users := []User{}
+		for id := offset; id < total && (ignoreLimit || id < offset+limit); id++ {
+			users = append(users, User{Id: id})
 		}
-	})
-
-	return u
+		json.NewEncoder(w).Encode(users)
+	}))
 }
 
-func readUsers(path string) ([]User, error) {
-	xmlFile, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func TestFindUsersLegacyPaging(t *testing.T) {
+	ts := legacyServer(5, false)
+	defer ts.Close()
+	u := &SearchClient{URL: ts.URL, AccessToken: "token", LegacyPaging: true}
+
+	cases := []struct {
+		Request  SearchRequest
+		Users    int
+		NextPage bool
+	}{
+		{SearchRequest{Limit: 2}, 2, true},
+		{SearchRequest{Limit: 2, Offset: 2}, 2, true},
+		{SearchRequest{Limit: 2, Offset: 4}, 1, false},
+		{SearchRequest{Limit: 5}, 5, false},
+		{SearchRequest{Limit: 0}, 0, true},
+	}
+	for caseNum, item := range cases {
+		res, err := u.FindUsers(item.Request)
+		if err != nil {
+			t.Errorf("[%d] unexpected error %v", caseNum, err)
+			continue
+		}
+		if len(res.Users) != item.Users || res.NextPage != item.NextPage || res.NextCursor != "" {
+			t.Errorf("[%d] got %d users, NextPage %v, cursor %q", caseNum, len(res.Users), res.NextPage, res.NextCursor)
+		}
+	}
+	if limit := ts.Requests()[0].FormValue("limit"); limit != "3" {
+		t.Errorf("expected one more user requested, got limit=%s", limit)
 	}
 
-	defer xmlFile.Close()
-
-	byteValue, err := ioutil.ReadAll(xmlFile)
-
-	if err != nil {
-		return nil, err
+	// сервер, который не смотрит на limit, обрезается и без LegacyPaging
+	ts = legacyServer(5, true)
+	defer ts.Close()
+	u = &SearchClient{URL: ts.URL, AccessToken: "token"}
+	res, err := u.FindUsers(SearchRequest{Limit: 2})
+	if err != nil || len(res.Users) != 2 || !res.NextPage {
+		t.Errorf("expected 2 users and the next page, got %+v %v", res, err)
 	}
+}
 
-	var xmlRoot XmlRoot
-	err = xml.Unmarshal(byteValue, &xmlRoot)
+func TestFindUsersAuth(t *testing.T) {
+	ts, srv := newTestServer(t, nil)
+	defer ts.Close()
 
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	ioutil.WriteFile(path, []byte(`{"tokens": [
+		{"token": "plain", "scopes": ["search"], "rate": 0.001},
+		{"token": "old", "scopes": ["search"], "expires": "2020-01-01T00:00:00Z"}
+	]}`), 0644)
+	tokens, err := searchserver.LoadTokens(path)
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
 	}
+	srv.SetTokens(tokens)
 
-	xmlUsers := xmlRoot.Users
-
-	result := make([]User, len(xmlUsers))
-
-	for i := 0; i < len(xmlUsers); i++ {
-		result[i].Id = xmlUsers[i].Id
-		result[i].Name = xmlUsers[i].FirstName + " " + xmlUsers[i].LastName
-		result[i].Age = xmlUsers[i].Age
-		result[i].About = xmlUsers[i].About
-		result[i].Gender = xmlUsers[i].Gender
+	u := &SearchClient{URL: ts.URL, AccessToken: "unknown"}
+	if _, err := u.FindUsers(SearchRequest{}); !errors.Is(err, ErrUnauthorized) || err.Error() != "Bad AccessToken" {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+	u.AccessToken = "old"
+	if _, err := u.FindUsers(SearchRequest{}); !errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrTokenExpired, got %v", err)
+	}
+	u.AccessToken = "plain"
+	if _, err := u.FindUsers(SearchRequest{Query: "cillum"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
 	}
 
-	return result, nil
-}
-
-func parseParams(limit, offset, query, orderField, orderBy string) SearchRequest {
-	l, err := strconv.ParseInt(limit, 10, 0)
-
-	if err != nil || l <= 0 {
-		l = 25
+	_, err = u.FindUsers(SearchRequest{})
+	rateErr := &RateLimitError{}
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &rateErr) || rateErr.RetryAfter < time.Minute {
+		t.Errorf("expected RateLimitError, got %v", err)
 	}
+}
 
-	o, err := strconv.ParseInt(offset, 10, 0)
+type roundTripFunc func(r *http.Request) (*http.Response, error)
 
-	if err != nil || o <= 0 {
-		o = 0
-	}
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
 
-	ord, err := strconv.ParseInt(orderBy, 10, 0)
+func TestFindUsersTransport(t *testing.T) {
+	calls := 0
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if r.Header.Get("AccessToken") != "token" || r.FormValue("limit") != "1" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`[{"Id":1}]`)),
+			Header:     http.Header{NextCursorHeader: []string{"next"}},
+		}, nil
+	})
 
+	u := &SearchClient{URL: "http://search.invalid/", AccessToken: "token", Transport: transport}
+	res, err := u.FindUsers(SearchRequest{Limit: 1})
 	if err != nil {
-		ord = OrderByAsIs
+		t.Fatal(err)
 	}
-
-	if orderField == "" {
-		orderField = "Name"
+	if len(res.Users) != 1 || !res.NextPage || res.NextCursor != "next" {
+		t.Errorf("wrong result %+v", res)
 	}
 
-	return SearchRequest{
-		Limit:      int(l),
-		Offset:     int(o),
-		Query:      query,
-		OrderField: orderField,
-		OrderBy:    int(ord),
+	u.Transport = nil
+	u.HTTPClient = &http.Client{Transport: transport}
+	if _, err := u.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the injected transport to be used twice, got %d", calls)
 	}
-}
-
-func validateParams(orderField string, orderBy int) error {
-	switch orderField {
-	case "Id":
-		fallthrough
-	case "Name":
-		fallthrough
-	case "":
-		fallthrough
-	case "Age":
-		break
-	default:
-		return fmt.Errorf("ErrorBadOrderField")
-	}
-
-	switch orderBy {
-	case OrderByAsIs:
-		fallthrough
-	case OrderByAsc:
-		fallthrough
-	case OrderByDesc:
-		break
-	default:
-		return fmt.Errorf(ErrorBadOrderField)
-	}
-
-	return nil
 }
@@ -0,0 +1,119 @@
+package main
+
+import "context"
+
+type pageResult struct {
+	resp *SearchResponse
+	err  error
+}
+
+// Pages обходит все страницы выдачи по req, начиная с req.Offset, и отдаёт их в fn по порядку.
+// req.Limit - размер страницы, 0 или больше MaxLimit означает MaxLimit.
+// Следующая страница запрашивается по NextCursor предыдущей, так что обход не
+// пропускает и не повторяет пользователей, если данные на сервере поменялись.
+// Страницы при этом идут строго по одной, window действует только при LegacyPaging:
+// тогда одновременно запрашивается до window страниц по смещениям, и в конце выдачи
+// может уйти до window-1 лишних запросов. Обход останавливается на первой ошибке
+// запроса, ошибке из fn или отмене ctx.
+func (srv *SearchClient) Pages(ctx context.Context, req SearchRequest, window int, fn func(users []User) error) error {
+	if req.Limit <= 0 || req.Limit > MaxLimit {
+		req.Limit = MaxLimit
+	}
+	if srv.LegacyPaging {
+		return srv.pagesByOffset(ctx, req, window, fn)
+	}
+
+	for {
+		resp, err := srv.FindUsersContext(ctx, req)
+		if err != nil {
+			return err
+		}
+		if len(resp.Users) > 0 {
+			if err := fn(resp.Users); err != nil {
+				return err
+			}
+		}
+		if !resp.NextPage {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// без курсора (сервер вернул больше limit) смещение считается от прежнего
+		if resp.NextCursor != "" {
+			req.Cursor, req.Offset = resp.NextCursor, 0
+		} else {
+			req.Offset += req.Limit
+		}
+	}
+}
+
+// pagesByOffset - Pages без курсоров: window страниц запрашивается одновременно,
+// незавершённые запросы отменяются при выходе
+func (srv *SearchClient) pagesByOffset(ctx context.Context, req SearchRequest, window int, fn func(users []User) error) error {
+	if window < 1 {
+		window = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// очередь запрошенных страниц в порядке смещений, каналы буферизованы,
+	// так что горутины отменённых запросов не зависнут
+	pending := make([]chan pageResult, 0, window)
+	next := req.Offset
+	fetch := func() {
+		page := req
+		page.Offset = next
+		next += req.Limit
+
+		result := make(chan pageResult, 1)
+		go func() {
+			resp, err := srv.FindUsersContext(ctx, page)
+			result <- pageResult{resp, err}
+		}()
+		pending = append(pending, result)
+	}
+
+	for i := 0; i < window; i++ {
+		fetch()
+	}
+	for {
+		var res pageResult
+		select {
+		case res = <-pending[0]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		pending = pending[1:]
+
+		// страница могла прийти одновременно с отменой
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if res.err != nil {
+			return res.err
+		}
+		if len(res.resp.Users) > 0 {
+			if err := fn(res.resp.Users); err != nil {
+				return err
+			}
+		}
+		if !res.resp.NextPage {
+			return nil
+		}
+		fetch()
+	}
+}
+
+// All - Pages по одному пользователю
+func (srv *SearchClient) All(ctx context.Context, req SearchRequest, window int, fn func(u User) error) error {
+	return srv.Pages(ctx, req, window, func(users []User) error {
+		for _, u := range users {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	offsets := []string{}
+	ts, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		offsets = append(offsets, r.FormValue("offset"))
+		if r.FormValue("cursor") == "" {
+			offsets[len(offsets)-1] += " without cursor"
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+	})
+	defer ts.Close()
+	u := &SearchClient{URL: ts.URL, AccessToken: "token"}
+
+	expected := []int{}
+	for id := 3; id < 35; id++ {
+		expected = append(expected, id)
+	}
+
+	for _, legacy := range []bool{false, true} {
+		// отдельный клиент: отменённые запросы окна ещё читают его после выхода из All
+		client := &SearchClient{URL: ts.URL, AccessToken: "token", LegacyPaging: legacy}
+		for _, window := range []int{0, 1, 4, 20} {
+			mu.Lock()
+			maxInFlight, offsets = 0, offsets[:0]
+			mu.Unlock()
+			got := []int{}
+			err := client.All(context.Background(), SearchRequest{Limit: 5, Offset: 3, OrderField: "Id", OrderBy: OrderByAsc}, window, func(user User) error {
+				got = append(got, user.Id)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("legacy %v, window %d: %s", legacy, window, err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("legacy %v, window %d: got %v, expected %v", legacy, window, got, expected)
+			}
+			mu.Lock()
+			requests, requested := maxInFlight, append([]string{}, offsets...)
+			mu.Unlock()
+			if requests > window && requests > 1 {
+				t.Errorf("legacy %v, window %d exceeded: %d requests at once", legacy, window, requests)
+			}
+			if legacy {
+				continue
+			}
+			// с курсорами страницы идут по одной, смещение есть только у первой
+			if requests != 1 {
+				t.Errorf("window %d: %d requests at once with cursors", window, requests)
+			}
+			for i, offset := range requested {
+				if i == 0 && offset != "3 without cursor" || i > 0 && offset != "0" {
+					t.Errorf("window %d: page %d requested at offset %q", window, i, offset)
+				}
+			}
+		}
+	}
+
+	pages := 0
+	u.Pages(context.Background(), SearchRequest{Query: "Boyd Wolf"}, 2, func(users []User) error {
+		pages++
+		if len(users) != 1 {
+			t.Errorf("unexpected page %v", users)
+		}
+		return nil
+	})
+	if pages != 1 {
+		t.Errorf("expected a single page, got %d", pages)
+	}
+}
+
+func TestPagesStop(t *testing.T) {
+	ts, _ := newTestServer(t, nil)
+	defer ts.Close()
+	u := &SearchClient{URL: ts.URL, AccessToken: "token"}
+
+	stop := errors.New("stop")
+	pages := 0
+	err := u.Pages(context.Background(), SearchRequest{Limit: 5}, 3, func(users []User) error {
+		pages++
+		if pages == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || pages != 2 {
+		t.Errorf("expected to stop at the second page, got %v after %d pages", err, pages)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pages = 0
+	err = u.Pages(ctx, SearchRequest{Limit: 5}, 3, func(users []User) error {
+		pages++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || pages != 1 {
+		t.Errorf("expected to stop after cancel, got %v after %d pages", err, pages)
+	}
+
+	err = u.Pages(context.Background(), SearchRequest{OrderField: "BAD"}, 3, func(users []User) error {
+		t.Errorf("unexpected page")
+		return nil
+	})
+	if !errors.Is(err, ErrBadOrderField) {
+		t.Errorf("expected ErrBadOrderField, got %v", err)
+	}
+}
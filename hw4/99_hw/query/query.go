@@ -0,0 +1,362 @@
+// Package query is the search language shared by SearchClient and
+// searchserver:
+//
+//	age>=30 gender:female about~"golang" Boyd
+//
+// A query is a list of terms that must all match. A term is either a
+// condition field<op>value or a bare word searched as a substring of the
+// schema's text fields. Operators:
+//
+//	:   equal, case-insensitive for strings
+//	=   equal
+//	!=  not equal
+//	~   contains, case-insensitive (strings only)
+//	< <= > >=  numbers only
+//
+// Values with spaces or operator characters are quoted, "\"" and "\\" are
+// escapes inside quotes. Sort specs are comma-separated fields with an
+// optional + or - direction, field lists of a projection are comma-separated
+// too. Field names are case-insensitive.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+)
+
+// Schema describes the fields a query may refer to
+type Schema struct {
+	Fields map[string]Kind // canonical names, e.g. "Age"
+	Text   []string        // fields a bare word is searched in
+}
+
+// lookup resolves a case-insensitive field name to its canonical form
+func (s *Schema) lookup(name string) (string, Kind, bool) {
+	for field, kind := range s.Fields {
+		if strings.EqualFold(field, name) {
+			return field, kind, true
+		}
+	}
+	return "", 0, false
+}
+
+// Error is a syntax or schema error, Pos is a byte offset in the input
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("col %d: %s", e.Pos+1, e.Msg)
+}
+
+type Op int
+
+const (
+	Eq       Op = iota // :
+	Exact              // =
+	Ne                 // !=
+	Contains           // ~
+	Lt
+	Le
+	Gt
+	Ge
+)
+
+// ops longest first, so that <= isn't read as <
+var ops = []struct {
+	text string
+	op   Op
+}{
+	{"!=", Ne}, {"<=", Le}, {">=", Ge},
+	{":", Eq}, {"=", Exact}, {"~", Contains}, {"<", Lt}, {">", Gt},
+}
+
+// Cond is a term of a query, Field is empty for a bare word
+type Cond struct {
+	Field string
+	Op    Op
+	Value string
+	Int   int // Value of an Int field
+}
+
+type Query struct {
+	Conds  []Cond
+	schema *Schema
+}
+
+// Record gives a query the values of a user: int for Int fields, string for String ones
+type Record interface {
+	Value(field string) interface{}
+}
+
+// Parse compiles a query against the schema, an empty query matches everything
+func Parse(input string, schema *Schema) (*Query, error) {
+	q := &Query{schema: schema}
+	p := parser{input: input}
+	for {
+		p.skipSpace()
+		if p.pos == len(input) {
+			return q, nil
+		}
+		cond, err := p.term(schema)
+		if err != nil {
+			return nil, err
+		}
+		q.Conds = append(q.Conds, cond)
+	}
+}
+
+// Match reports whether the record satisfies every term
+func (q *Query) Match(r Record) bool {
+	for _, c := range q.Conds {
+		if !q.match(c, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchFields is Match without the bare words, for callers searching them
+// their own way, e.g. in a full-text index
+func (q *Query) MatchFields(r Record) bool {
+	for _, c := range q.Conds {
+		if c.Field != "" && !q.match(c, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Words are the bare words of the query
+func (q *Query) Words() []string {
+	words := []string{}
+	for _, c := range q.Conds {
+		if c.Field == "" {
+			words = append(words, c.Value)
+		}
+	}
+	return words
+}
+
+func (q *Query) match(c Cond, r Record) bool {
+	if c.Field == "" {
+		for _, field := range q.schema.Text {
+			if s, _ := r.Value(field).(string); strings.Contains(s, c.Value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch v := r.Value(c.Field).(type) {
+	case int:
+		switch c.Op {
+		case Eq, Exact:
+			return v == c.Int
+		case Ne:
+			return v != c.Int
+		case Lt:
+			return v < c.Int
+		case Le:
+			return v <= c.Int
+		case Gt:
+			return v > c.Int
+		case Ge:
+			return v >= c.Int
+		}
+	case string:
+		switch c.Op {
+		case Eq:
+			return strings.EqualFold(v, c.Value)
+		case Exact:
+			return v == c.Value
+		case Ne:
+			return v != c.Value
+		case Contains:
+			return strings.Contains(strings.ToLower(v), strings.ToLower(c.Value))
+		}
+	}
+	return false
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func (p *parser) term(schema *Schema) (Cond, error) {
+	start := p.pos
+	end := p.pos
+	for end < len(p.input) && isNameChar(p.input[end]) {
+		end++
+	}
+	op, opLen := Op(0), 0
+	if end > start {
+		for _, o := range ops {
+			if strings.HasPrefix(p.input[end:], o.text) {
+				op, opLen = o.op, len(o.text)
+				break
+			}
+		}
+	}
+	if opLen == 0 {
+		value, err := p.value()
+		if err != nil {
+			return Cond{}, err
+		}
+		return Cond{Value: value}, nil
+	}
+
+	name := p.input[start:end]
+	field, kind, ok := schema.lookup(name)
+	if !ok {
+		return Cond{}, &Error{start, fmt.Sprintf("unknown field %q", name)}
+	}
+	opPos := end
+	p.pos = end + opLen
+
+	valuePos := p.pos
+	if p.pos == len(p.input) || isSpace(p.input[p.pos]) {
+		return Cond{}, &Error{valuePos, fmt.Sprintf("missing value for %s", field)}
+	}
+	value, err := p.value()
+	if err != nil {
+		return Cond{}, err
+	}
+
+	cond := Cond{Field: field, Op: op, Value: value}
+	switch {
+	case kind == Int && op == Contains:
+		return Cond{}, &Error{opPos, fmt.Sprintf("%s is a number, ~ needs a string field", field)}
+	case kind == String && op >= Lt:
+		return Cond{}, &Error{opPos, fmt.Sprintf("%s is a string, comparison needs a number field", field)}
+	case kind == Int:
+		if cond.Int, err = strconv.Atoi(value); err != nil {
+			return Cond{}, &Error{valuePos, fmt.Sprintf("%s needs a number, got %q", field, value)}
+		}
+	}
+	return cond, nil
+}
+
+// value reads a bare or a quoted word
+func (p *parser) value() (string, error) {
+	if p.input[p.pos] != '"' {
+		start := p.pos
+		for p.pos < len(p.input) && !isSpace(p.input[p.pos]) {
+			p.pos++
+		}
+		return p.input[start:p.pos], nil
+	}
+
+	quote := p.pos
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		p.pos++
+		switch {
+		case c == '"':
+			if p.pos < len(p.input) && !isSpace(p.input[p.pos]) {
+				return "", &Error{p.pos, "expected a space after the closing quote"}
+			}
+			return b.String(), nil
+		case c == '\\' && p.pos < len(p.input) && (p.input[p.pos] == '"' || p.input[p.pos] == '\\'):
+			b.WriteByte(p.input[p.pos])
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", &Error{quote, "unterminated quote"}
+}
+
+type Direction int
+
+const (
+	Default Direction = iota // the direction of the request
+	Asc
+	Desc
+)
+
+type SortKey struct {
+	Field string
+	Dir   Direction
+}
+
+// ParseSort reads "Age,-Name,+Id", empty spec gives no keys
+func ParseSort(spec string, schema *Schema) ([]SortKey, error) {
+	keys := []SortKey{}
+	err := splitList(spec, func(item string, pos int) error {
+		key := SortKey{}
+		switch item[0] {
+		case '+':
+			key.Dir, item, pos = Asc, item[1:], pos+1
+		case '-':
+			key.Dir, item, pos = Desc, item[1:], pos+1
+		}
+		field, _, ok := schema.lookup(item)
+		if !ok {
+			return &Error{pos, fmt.Sprintf("unknown sort field %q", item)}
+		}
+		key.Field = field
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+// ParseFields reads the field list of a projection, "Id,Name"
+func ParseFields(spec string, schema *Schema) ([]string, error) {
+	fields := []string{}
+	err := splitList(spec, func(item string, pos int) error {
+		field, _, ok := schema.lookup(item)
+		if !ok {
+			return &Error{pos, fmt.Sprintf("unknown field %q", item)}
+		}
+		fields = append(fields, field)
+		return nil
+	})
+	return fields, err
+}
+
+func splitList(spec string, fn func(item string, pos int) error) error {
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	pos := 0
+	for _, item := range strings.Split(spec, ",") {
+		trimmed := strings.TrimSpace(item)
+		at := pos + strings.Index(item, trimmed)
+		if trimmed == "" {
+			return &Error{pos, "empty item"}
+		}
+		if err := fn(trimmed, at); err != nil {
+			return err
+		}
+		pos += len(item) + 1
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testSchema = &Schema{
+	Fields: map[string]Kind{"Id": Int, "Age": Int, "Name": String, "About": String, "Gender": String},
+	Text:   []string{"Name", "About"},
+}
+
+type user map[string]interface{}
+
+func (u user) Value(field string) interface{} {
+	return u[field]
+}
+
+func TestMatch(t *testing.T) {
+	boyd := user{"Id": 0, "Age": 22, "Name": "Boyd Wolf", "About": "Likes golang", "Gender": "male"}
+	hilda := user{"Id": 1, "Age": 31, "Name": "Hilda Mayer", "About": "Sit commodo", "Gender": "female"}
+
+	cases := []struct {
+		Query   string
+		Matches []bool // boyd, hilda
+	}{
+		{"", []bool{true, true}},
+		{"age>=30 gender:female", []bool{false, true}},
+		{"AGE<30", []bool{true, false}},
+		{"age>22", []bool{false, true}},
+		{"age<=22 id=0", []bool{true, false}},
+		{"id!=0", []bool{false, true}},
+		{`about~"GOLANG"`, []bool{true, false}},
+		{"gender:MALE", []bool{true, false}},
+		{"gender=MALE", []bool{false, false}},
+		{"name!=Boyd", []bool{true, true}},
+		{`Wolf Boyd`, []bool{true, false}},
+		{`"Hilda Mayer"`, []bool{false, true}},
+		{`name:"boyd wolf"`, []bool{true, false}},
+		{`about~"say \"hi\""`, []bool{false, false}},
+		{"commodo", []bool{false, true}},
+		{"+1", []bool{false, false}},
+	}
+	for caseNum, item := range cases {
+		q, err := Parse(item.Query, testSchema)
+		if err != nil {
+			t.Errorf("[%d] %s: %s", caseNum, item.Query, err)
+			continue
+		}
+		got := []bool{q.Match(boyd), q.Match(hilda)}
+		if !reflect.DeepEqual(got, item.Matches) {
+			t.Errorf("[%d] %s: got %v, expected %v", caseNum, item.Query, got, item.Matches)
+		}
+	}
+
+	q, _ := Parse(`about~"say \"hi\" \\o/"`, testSchema)
+	if q.Conds[0].Value != `say "hi" \o/` {
+		t.Errorf("wrong unquoting: %q", q.Conds[0].Value)
+	}
+}
+
+func TestWords(t *testing.T) {
+	q, err := Parse(`golang age>30 "Rob Pike"`, testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if words := q.Words(); !reflect.DeepEqual(words, []string{"golang", "Rob Pike"}) {
+		t.Errorf("got words %q", words)
+	}
+	if !q.MatchFields(user{"Age": 31}) || q.MatchFields(user{"Age": 30}) {
+		t.Errorf("MatchFields must check the conditions only")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		Query string
+		Error string
+	}{
+		{"age>=30 height>2", `col 9: unknown field "height"`},
+		{"age>=x", `col 6: Age needs a number, got "x"`},
+		{"age~3", "col 4: Age is a number, ~ needs a string field"},
+		{"name<b", "col 5: Name is a string, comparison needs a number field"},
+		{"gender: female", "col 8: missing value for Gender"},
+		{"name:", "col 6: missing value for Name"},
+		{`about~"golang`, "col 7: unterminated quote"},
+		{`about~"go"lang`, "col 11: expected a space after the closing quote"},
+	}
+	for caseNum, item := range cases {
+		_, err := Parse(item.Query, testSchema)
+		if _, ok := err.(*Error); !ok || err.Error() != item.Error {
+			t.Errorf("[%d] %s: got %v, expected %s", caseNum, item.Query, err, item.Error)
+		}
+	}
+}
+
+func TestParseLists(t *testing.T) {
+	keys, err := ParseSort("age, -name,+Id", testSchema)
+	expected := []SortKey{{"Age", Default}, {"Name", Desc}, {"Id", Asc}}
+	if err != nil || !reflect.DeepEqual(keys, expected) {
+		t.Errorf("got %v %v, expected %v", keys, err, expected)
+	}
+	if keys, err := ParseSort("", testSchema); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys, got %v %v", keys, err)
+	}
+
+	fields, err := ParseFields("id,NAME", testSchema)
+	if err != nil || !reflect.DeepEqual(fields, []string{"Id", "Name"}) {
+		t.Errorf("got %v %v", fields, err)
+	}
+
+	errors := []struct {
+		Spec  string
+		Error string
+	}{
+		{"Age,-Height", `col 6: unknown sort field "Height"`},
+		{"Age,,Id", "col 5: empty item"},
+	}
+	for caseNum, item := range errors {
+		if _, err := ParseSort(item.Spec, testSchema); err == nil || err.Error() != item.Error {
+			t.Errorf("[%d] %s: got %v, expected %s", caseNum, item.Spec, err, item.Error)
+		}
+	}
+	if _, err := ParseFields("Id, Salary", testSchema); err == nil || err.Error() != `col 5: unknown field "Salary"` {
+		t.Errorf("got %v", err)
+	}
+}
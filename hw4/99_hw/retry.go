@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy - повторы поиска при временных ошибках: таймаутах, недоступном сервере и 5xx.
+// Поиск идемпотентен, так что повторять его безопасно
+type RetryPolicy struct {
+	// MaxAttempts - сколько всего попыток, 0 и 1 - без повторов
+	MaxAttempts int
+	// BaseDelay - пауза перед второй попыткой, дальше она удваивается до MaxDelay
+	BaseDelay time.Duration
+	// MaxDelay - предел паузы, 0 - без предела
+	MaxDelay time.Duration
+	// Jitter - случайная доля паузы от 0 до 1, на неё пауза сокращается,
+	// чтобы клиенты не повторяли запросы одновременно
+	Jitter float64
+}
+
+func (p *RetryPolicy) attempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// delay - пауза после неудачной попытки номер attempt, считая с 1
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay == 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+func isTransient(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrServer)
+}
+
+// retryCanceled - ctx завершился в паузе перед повтором. errors.Is верно и для
+// ошибки ctx, и для ошибки последней попытки
+type retryCanceled struct {
+	ctx  error
+	last error
+}
+
+func (e *retryCanceled) Error() string {
+	return e.ctx.Error() + " while waiting to retry: " + e.last.Error()
+}
+
+func (e *retryCanceled) Unwrap() error {
+	return e.ctx
+}
+
+func (e *retryCanceled) Is(target error) bool {
+	return errors.Is(e.last, target)
+}
+
+type BreakerState int32
+
+const (
+	// BreakerClosed - запросы идут как обычно
+	BreakerClosed BreakerState = iota
+	// BreakerOpen - запросы сразу завершаются с ErrCircuitOpen
+	BreakerOpen
+	// BreakerHalfOpen - после Cooldown пропускается один пробный запрос
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker размыкается после Threshold (не меньше 1) временных ошибок подряд (5xx, таймауты)
+// и не пускает запросы к серверу Cooldown. Потом пробный запрос решает,
+// замкнуться снова или подождать ещё
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool             // пробный запрос уже идёт
+	now      func() time.Time // для тестов, nil - time.Now
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.clock().Sub(b.openedAt) >= b.Cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// allow пропускает запрос или возвращает ErrCircuitOpen. probe - запрос пробный,
+// только его итог решает, замкнуться ли breaker после Cooldown
+func (b *CircuitBreaker) allow() (probe bool, err error) {
+	if b == nil {
+		return false, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.clock().Sub(b.openedAt) >= b.Cooldown {
+		b.state = BreakerHalfOpen
+	}
+	switch {
+	case b.state == BreakerOpen, b.state == BreakerHalfOpen && b.probing:
+		return false, newSearchError(ErrCircuitOpen, "SearchServer unavailable: circuit breaker is open")
+	case b.state == BreakerHalfOpen:
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// record учитывает итог запроса, пропущенного allow с тем же probe,
+// и сообщает, разомкнулся ли breaker
+func (b *CircuitBreaker) record(probe bool, err error) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	} else if b.state != BreakerClosed {
+		// запрос ушёл до размыкания и закончился только сейчас, о сервере
+		// после Cooldown он ничего не говорит
+		return false
+	}
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		// отменённый запрос ничего не говорит о сервере
+		return false
+	case err != nil && isTransient(err):
+		b.failures++
+		if probe || b.failures >= b.Threshold {
+			b.state, b.openedAt, b.failures = BreakerOpen, b.clock(), 0
+			return true
+		}
+	default:
+		b.state, b.failures = BreakerClosed, 0
+	}
+	return false
+}
+
+const (
+	metricRequests = iota
+	metricFailures
+	metricRetries
+	metricRejected
+	metricBreakerOpened
+	metricCacheHits
+	metricNotModified
+	metricCount
+)
+
+// Metrics - счётчики клиента, включая кеш, безопасны для одновременного использования
+type Metrics struct {
+	counters [metricCount]int64
+}
+
+type MetricsSnapshot struct {
+	Requests      int64 // запросов к серверу, включая повторы
+	Failures      int64 // из них неудачных
+	Retries       int64 // повторов
+	Rejected      int64 // запросов, отклонённых разомкнутым breaker
+	BreakerOpened int64 // сколько раз breaker размыкался
+	CacheHits     int64 // ответов из кеша без запроса
+	NotModified   int64 // ответов из кеша после 304
+}
+
+func (m *Metrics) add(metric int) {
+	if m != nil {
+		atomic.AddInt64(&m.counters[metric], 1)
+	}
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Requests:      atomic.LoadInt64(&m.counters[metricRequests]),
+		Failures:      atomic.LoadInt64(&m.counters[metricFailures]),
+		Retries:       atomic.LoadInt64(&m.counters[metricRetries]),
+		Rejected:      atomic.LoadInt64(&m.counters[metricRejected]),
+		BreakerOpened: atomic.LoadInt64(&m.counters[metricBreakerOpened]),
+		CacheHits:     atomic.LoadInt64(&m.counters[metricCacheHits]),
+		NotModified:   atomic.LoadInt64(&m.counters[metricNotModified]),
+	}
+}
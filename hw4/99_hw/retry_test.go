@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, d := range expected {
+		if got := p.delay(i + 1); got != d*time.Millisecond {
+			t.Errorf("attempt %d: got %s, expected %s", i+1, got, d*time.Millisecond)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(2); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("jittered delay %s out of [10ms, 20ms]", d)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int64
+	ts, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	defer ts.Close()
+
+	metrics := &Metrics{}
+	u := &SearchClient{
+		URL:         ts.URL,
+		AccessToken: "token",
+		Retry:       &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		Metrics:     metrics,
+	}
+	res, err := u.FindUsers(SearchRequest{Limit: 1})
+	if err != nil || len(res.Users) != 1 {
+		t.Fatalf("expected a retried success, got %v %+v", err, res)
+	}
+	expected := MetricsSnapshot{Requests: 3, Failures: 2, Retries: 2}
+	if got := metrics.Snapshot(); got != expected {
+		t.Errorf("got metrics %+v, expected %+v", got, expected)
+	}
+
+	// client errors are not retried
+	if _, err := u.FindUsers(SearchRequest{OrderField: "BAD"}); !errors.Is(err, ErrBadOrderField) {
+		t.Errorf("expected ErrBadOrderField, got %v", err)
+	}
+	if got := metrics.Snapshot().Requests; got != 4 {
+		t.Errorf("bad request was retried: %d requests", got)
+	}
+
+	// a cancelled context interrupts the backoff
+	atomic.StoreInt64(&calls, 0)
+	u.Retry = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = u.FindUsersContext(ctx, SearchRequest{})
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrServer) {
+		t.Errorf("expected the deadline wrapping the last server error, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	var calls int64
+	ts, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	defer ts.Close()
+
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+	metrics := &Metrics{}
+	u := &SearchClient{URL: ts.URL, AccessToken: "token", Breaker: breaker, Metrics: metrics}
+
+	for i := 0; i < 2; i++ {
+		if _, err := u.FindUsers(SearchRequest{}); !errors.Is(err, ErrServer) {
+			t.Fatalf("expected ErrServer, got %v", err)
+		}
+	}
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("expected an open breaker, got %s", breaker.State())
+	}
+	if _, err := u.FindUsers(SearchRequest{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("open breaker let a request through: %d calls", calls)
+	}
+
+	// a failed probe opens it again
+	now = now.Add(time.Minute)
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatalf("expected a half-open breaker, got %s", breaker.State())
+	}
+	u.FindUsers(SearchRequest{})
+	if breaker.State() != BreakerOpen || calls != 3 {
+		t.Fatalf("expected an open breaker after one probe, got %s and %d calls", breaker.State(), calls)
+	}
+
+	now = now.Add(time.Minute)
+	atomic.StoreInt32(&failing, 0)
+	if _, err := u.FindUsers(SearchRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if breaker.State() != BreakerClosed {
+		t.Errorf("expected a closed breaker, got %s", breaker.State())
+	}
+
+	expected := MetricsSnapshot{Requests: 4, Failures: 3, Rejected: 1, BreakerOpened: 2}
+	if got := metrics.Snapshot(); got != expected {
+		t.Errorf("got metrics %+v, expected %+v", got, expected)
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+	failure := newSearchError(ErrServer, "SearchServer fatal error")
+
+	// two requests in flight, the second one fails and opens the breaker
+	slow, _ := breaker.allow()
+	fast, _ := breaker.allow()
+	if !breaker.record(fast, failure) {
+		t.Fatalf("expected the breaker to open, got %s", breaker.State())
+	}
+
+	now = now.Add(time.Minute)
+	probe, err := breaker.allow()
+	if err != nil || !probe {
+		t.Fatalf("expected a probe, got %v %v", probe, err)
+	}
+
+	// the slow request succeeds, but it was sent before the breaker opened
+	if breaker.record(slow, nil) || breaker.State() != BreakerHalfOpen {
+		t.Errorf("a request older than the probe changed the breaker: %s", breaker.State())
+	}
+	if _, err := breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen while the probe is in flight, got %v", err)
+	}
+
+	if !breaker.record(probe, failure) || breaker.State() != BreakerOpen {
+		t.Errorf("the failed probe must open the breaker, got %s", breaker.State())
+	}
+}
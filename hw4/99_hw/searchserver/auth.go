@@ -0,0 +1,209 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Scopes a token may have
+const (
+	ScopeSearch   = "search"   // any search
+	ScopeFullText = "fulltext" // bare words in the query
+)
+
+// Error codes of AuthError
+const (
+	ErrorBadToken     = "ErrorBadToken"
+	ErrorTokenExpired = "ErrorTokenExpired"
+	ErrorForbidden    = "ErrorForbidden"
+	ErrorRateLimited  = "ErrorRateLimited"
+)
+
+// Token is an entry of the token file:
+//
+//	{"tokens": [
+//	  {"token": "s3cr3t", "name": "dashboards", "scopes": ["search", "fulltext"],
+//	   "expires": "2027-01-01T00:00:00Z", "rate": 5, "burst": 10}
+//	]}
+type Token struct {
+	Token   string    `json:"token"`
+	Name    string    `json:"name"` // who it was issued to
+	Scopes  []string  `json:"scopes"`
+	Expires time.Time `json:"expires"` // zero - never
+	Rate    float64   `json:"rate"`    // requests per second, 0 - unlimited
+	Burst   int       `json:"burst"`   // requests at once, at least 1
+}
+
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenFile struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// AuthError is answered with Status, 401, 403 or 429
+type AuthError struct {
+	Status     int
+	Code       string
+	Detail     string
+	RetryAfter time.Duration // for 429
+}
+
+func (e *AuthError) Error() string {
+	return e.Code
+}
+
+// TokenStore keeps the tokens of a JSON file and reloads them when the file
+// changes. Rate limits live apart from the file, a reload doesn't reset them.
+type TokenStore struct {
+	file watchedFile
+	// ReloadInterval - how often the file is checked, 0 - on every request
+	ReloadInterval time.Duration
+
+	mu     sync.RWMutex
+	tokens map[string]*Token
+
+	limits  sync.Mutex
+	buckets map[string]*bucket
+
+	now func() time.Time // for tests, nil - time.Now
+}
+
+// bucket is a token bucket of the rate limit
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func LoadTokens(path string) (*TokenStore, error) {
+	ts := &TokenStore{
+		file:           watchedFile{path: path},
+		ReloadInterval: time.Second,
+		buckets:        make(map[string]*bucket),
+	}
+	if err := ts.Reload(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Reload reads the file unconditionally
+func (ts *TokenStore) Reload() error {
+	return ts.file.reload(ts.load)
+}
+
+// Err is the error of the last reload, nil if it succeeded
+func (ts *TokenStore) Err() error {
+	return ts.file.err()
+}
+
+func (ts *TokenStore) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f := tokenFile{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	tokens := make(map[string]*Token, len(f.Tokens))
+	for i := range f.Tokens {
+		tokens[f.Tokens[i].Token] = &f.Tokens[i]
+	}
+	ts.mu.Lock()
+	ts.tokens = tokens
+	ts.mu.Unlock()
+
+	// the limits of removed tokens go away with them
+	ts.limits.Lock()
+	for token := range ts.buckets {
+		if t, ok := tokens[token]; !ok || t.Rate <= 0 {
+			delete(ts.buckets, token)
+		}
+	}
+	ts.limits.Unlock()
+	return nil
+}
+
+func (ts *TokenStore) clock() time.Time {
+	if ts.now != nil {
+		return ts.now()
+	}
+	return time.Now()
+}
+
+// Lookup returns the token if it is known, expired ones included
+func (ts *TokenStore) Lookup(token string) (*Token, bool) {
+	ts.file.reloadIfChanged(ts.ReloadInterval, ts.load)
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	t, ok := ts.tokens[token]
+	return t, ok
+}
+
+// Authorize checks the token, its scope and rate limit, it takes a request
+// from the limit even if the scope is missing
+func (ts *TokenStore) Authorize(token, scope string) (*Token, error) {
+	t, ok := ts.Lookup(token)
+	if !ok {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Code: ErrorBadToken, Detail: "unknown token"}
+	}
+	now := ts.clock()
+	if !t.Expires.IsZero() && now.After(t.Expires) {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Code: ErrorTokenExpired,
+			Detail: "token expired at " + t.Expires.Format(time.RFC3339)}
+	}
+	if wait := ts.take(t, now); wait > 0 {
+		return nil, &AuthError{Status: http.StatusTooManyRequests, Code: ErrorRateLimited,
+			Detail: "rate limit of " + strconv.FormatFloat(t.Rate, 'f', -1, 64) + " requests per second", RetryAfter: wait}
+	}
+	if err := checkScope(t, scope); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func checkScope(t *Token, scope string) error {
+	if !t.HasScope(scope) {
+		return &AuthError{Status: http.StatusForbidden, Code: ErrorForbidden, Detail: "token has no scope " + scope}
+	}
+	return nil
+}
+
+// take removes a request from the bucket of the token, or tells how long to
+// wait for one
+func (ts *TokenStore) take(t *Token, now time.Time) time.Duration {
+	if t.Rate <= 0 {
+		return 0
+	}
+	burst := float64(t.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	ts.limits.Lock()
+	defer ts.limits.Unlock()
+	b, ok := ts.buckets[t.Token]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		ts.buckets[t.Token] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*t.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / t.Rate * float64(time.Second))
+}
@@ -0,0 +1,119 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testTokens = `{"tokens": [
+	{"token": "full", "name": "dashboards", "scopes": ["search", "fulltext"]},
+	{"token": "plain", "scopes": ["search"], "rate": 1, "burst": 2},
+	{"token": "none", "scopes": []},
+	{"token": "old", "scopes": ["search"], "expires": "2020-01-01T00:00:00Z"}
+]}`
+
+func newAuthServer(t *testing.T) (*Server, *TokenStore, string) {
+	srv, err := New(writeDataset(t, testDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	if err := ioutil.WriteFile(path, []byte(testTokens), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetTokens(tokens)
+	return srv, tokens, path
+}
+
+func TestTokens(t *testing.T) {
+	srv, tokens, _ := newAuthServer(t)
+	now := time.Now()
+	tokens.now = func() time.Time { return now }
+
+	cases := []struct {
+		Token  string
+		Query  string
+		Status int
+		Error  string
+	}{
+		{"", "", http.StatusUnauthorized, ErrorBadToken},
+		{"unknown", "", http.StatusUnauthorized, ErrorBadToken},
+		{"old", "", http.StatusUnauthorized, ErrorTokenExpired},
+		{"none", "", http.StatusForbidden, ErrorForbidden},
+		{"full", "query=cillum", http.StatusOK, ""},
+		{"plain", "query=age>21", http.StatusOK, ""},
+		{"plain", "query=cillum", http.StatusForbidden, ErrorForbidden},
+		// the burst of 2 is spent by the two requests above
+		{"plain", "", http.StatusTooManyRequests, ErrorRateLimited},
+	}
+	for caseNum, item := range cases {
+		r := httptest.NewRequest("GET", "/?"+item.Query, nil)
+		if item.Token != "" {
+			r.Header.Set("AccessToken", item.Token)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		errResp := ErrorResponse{}
+		json.Unmarshal(w.Body.Bytes(), &errResp)
+		if w.Code != item.Status || errResp.Error != item.Error {
+			t.Errorf("[%d] %s %s: got %d %s, expected %d %s", caseNum, item.Token, item.Query, w.Code, w.Body, item.Status, item.Error)
+		}
+		if item.Status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("[%d] expected Retry-After: 1, got %q", caseNum, w.Header().Get("Retry-After"))
+		}
+	}
+
+	// a second refills the bucket with one request
+	now = now.Add(time.Second)
+	if _, err := tokens.Authorize("plain", ScopeSearch); err != nil {
+		t.Errorf("expected a refilled bucket, got %v", err)
+	}
+	_, err := tokens.Authorize("plain", ScopeSearch)
+	if aerr, ok := err.(*AuthError); !ok || aerr.RetryAfter != time.Second {
+		t.Errorf("expected to wait a second, got %#v", err)
+	}
+}
+
+func TestTokensReload(t *testing.T) {
+	srv, tokens, path := newAuthServer(t)
+	tokens.ReloadInterval = 0
+	if code, _ := get(t, srv, "", "plain"); code != http.StatusOK {
+		t.Fatalf("plain token rejected: %d", code)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"tokens": [{"token": "new", "scopes": ["search"]}]}`), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if code, _ := get(t, srv, "", "new"); code != http.StatusOK {
+		t.Errorf("new token rejected: %d", code)
+	}
+	if code, _ := get(t, srv, "", "full"); code != http.StatusUnauthorized {
+		t.Errorf("removed token accepted: %d", code)
+	}
+	tokens.limits.Lock()
+	if len(tokens.buckets) != 0 {
+		t.Errorf("the bucket of a removed token is kept: %v", tokens.buckets)
+	}
+	tokens.limits.Unlock()
+
+	ioutil.WriteFile(path, []byte(`{"tokens": [`), 0644)
+	os.Chtimes(path, later.Add(time.Minute), later.Add(time.Minute))
+	if code, _ := get(t, srv, "", "new"); code != http.StatusOK || tokens.Err() == nil {
+		t.Errorf("a broken file must keep the old tokens: %d %v", code, tokens.Err())
+	}
+
+	if _, err := LoadTokens(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,49 @@
+package searchserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// cursor points right after a user in a sort order. It keeps the sort values
+// of that user, not its position, so pages stay consistent when the dataset
+// is reloaded between requests: removed or added users don't shift them.
+type cursor struct {
+	Order string  `json:"o"` // the order it was made for, see sortKeys.String
+	Query string  `json:"q"`
+	Id    int     `json:"i"`
+	Age   int     `json:"a,omitempty"`
+	Name  string  `json:"n,omitempty"`
+	Score float64 `json:"s,omitempty"` // ranks change as the dataset does, unlike the other keys
+}
+
+var errCursorMismatch = errors.New("cursor was made for another query or order")
+
+func encodeCursor(u *User, order, query string) string {
+	j, _ := json.Marshal(cursor{Order: order, Query: query, Id: u.Id, Age: u.Age, Name: u.Name, Score: u.Score})
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+func decodeCursor(token, order, query string) (*User, error) {
+	j, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	c := cursor{}
+	if err := json.Unmarshal(j, &c); err != nil {
+		return nil, err
+	}
+	if c.Order != order || c.Query != query {
+		return nil, errCursorMismatch
+	}
+	return &User{Id: c.Id, Age: c.Age, Name: c.Name, Score: c.Score}, nil
+}
+
+// seek returns the index of the first user after the cursor, users are sorted by keys
+func seek(users []User, keys sortKeys, after *User) int {
+	return sort.Search(len(users), func(i int) bool {
+		return keys.less(after, &users[i])
+	})
+}
@@ -0,0 +1,152 @@
+package searchserver
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+
+	// set by a full-text query only
+	Score   float64 `json:",omitempty"` // BM25 relevance
+	Snippet string  `json:",omitempty"` // matches wrapped in <em></em>, HTML-escaped
+}
+
+// Value implements query.Record
+func (u *User) Value(field string) interface{} {
+	switch field {
+	case "Id":
+		return u.Id
+	case "Name":
+		return u.Name
+	case "Age":
+		return u.Age
+	case "About":
+		return u.About
+	case "Gender":
+		return u.Gender
+	}
+	return nil
+}
+
+// xmlUser is a <row> of dataset.xml, only the fields the search needs
+type xmlUser struct {
+	Id        int    `xml:"id"`
+	Age       int    `xml:"age"`
+	FirstName string `xml:"first_name"`
+	LastName  string `xml:"last_name"`
+	Gender    string `xml:"gender"`
+	About     string `xml:"about"`
+
+	// full-text search only
+	Company       string `xml:"company"`
+	Email         string `xml:"email"`
+	Phone         string `xml:"phone"`
+	Address       string `xml:"address"`
+	EyeColor      string `xml:"eyeColor"`
+	FavoriteFruit string `xml:"favoriteFruit"`
+}
+
+// Dataset keeps the users of dataset.xml in memory and reloads them when the
+// file changes. A broken file doesn't replace the users loaded before it.
+type Dataset struct {
+	file watchedFile
+	// ReloadInterval - how often Users checks the file, 0 - on every call.
+	// Set it before the dataset is used.
+	ReloadInterval time.Duration
+
+	mu    sync.RWMutex
+	users []User
+	index *index // users[i] is the document i
+}
+
+func LoadDataset(path string) (*Dataset, error) {
+	d := &Dataset{file: watchedFile{path: path}, ReloadInterval: time.Second}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Users returns the current snapshot, it must not be modified
+func (d *Dataset) Users() []User {
+	users, _ := d.snapshot()
+	return users
+}
+
+func (d *Dataset) snapshot() ([]User, *index) {
+	d.file.reloadIfChanged(d.ReloadInterval, d.load)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.users, d.index
+}
+
+// Reload reads the file unconditionally
+func (d *Dataset) Reload() error {
+	return d.file.reload(d.load)
+}
+
+// Err is the error of the last reload, nil if it succeeded
+func (d *Dataset) Err() error {
+	return d.file.err()
+}
+
+func (d *Dataset) load(path string) error {
+	users, index, err := readUsers(path)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.users, d.index = users, index
+	d.mu.Unlock()
+	return nil
+}
+
+// readUsers decodes rows one by one instead of unmarshalling the whole document
+// and indexes them on the way
+func readUsers(path string) ([]User, *index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(file)
+	result := make([]User, 0)
+	index := newIndex()
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return result, index, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		row := xmlUser{}
+		if err := decoder.DecodeElement(&row, &start); err != nil {
+			return nil, nil, err
+		}
+		u := User{
+			Id:     row.Id,
+			Name:   row.FirstName + " " + row.LastName,
+			Age:    row.Age,
+			About:  row.About,
+			Gender: row.Gender,
+		}
+		result = append(result, u)
+		index.add(indexedFields(&row, &u))
+	}
+}
@@ -0,0 +1,195 @@
+package searchserver
+
+import (
+	"html"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 parameters, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+const (
+	snippetRadius  = 60 // bytes of context around the first match
+	highlightOpen  = "<em>"
+	highlightClose = "</em>"
+)
+
+type posting struct {
+	doc int // index of the user in the dataset
+	tf  int
+}
+
+// index is an inverted index over the text fields of the users, built once
+// per load of the dataset and never changed after that
+type index struct {
+	postings map[string][]posting
+	docLen   []int
+	total    int        // sum of docLen
+	fields   [][]string // texts of each user, snippets are cut from them
+}
+
+// indexedFields of xmlUser, in the order snippets look for a match
+func indexedFields(row *xmlUser, u *User) []string {
+	return []string{u.About, u.Name, row.Company, row.Address, row.Email, row.Phone, row.Gender, row.EyeColor, row.FavoriteFruit}
+}
+
+func newIndex() *index {
+	return &index{postings: make(map[string][]posting)}
+}
+
+func (ix *index) add(fields []string) {
+	doc := len(ix.docLen)
+	tf := make(map[string]int)
+	length := 0
+	for _, field := range fields {
+		for _, t := range tokenize(field) {
+			tf[t.term]++
+			length++
+		}
+	}
+	for term, n := range tf {
+		ix.postings[term] = append(ix.postings[term], posting{doc, n})
+	}
+	ix.docLen = append(ix.docLen, length)
+	ix.total += length
+	ix.fields = append(ix.fields, fields)
+}
+
+// search scores the users having every term, BM25 summed over the terms
+func (ix *index) search(terms []string) map[int]float64 {
+	scores := make(map[int]float64)
+	n := float64(len(ix.docLen))
+	avgLen := float64(ix.total) / n
+	for i, term := range terms {
+		postings := ix.postings[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		next := make(map[int]float64, len(postings))
+		for _, p := range postings {
+			prev, ok := scores[p.doc]
+			if i > 0 && !ok {
+				continue
+			}
+			tf := float64(p.tf)
+			norm := tf + bm25K1*(1-bm25B+bm25B*float64(ix.docLen[p.doc])/avgLen)
+			next[p.doc] = prev + idf*tf*(bm25K1+1)/norm
+		}
+		scores = next
+	}
+	return scores
+}
+
+// snippet cuts the first field of the user mentioning a term around that
+// mention and highlights every term in the cut. The text is HTML-escaped,
+// the highlight tags are the only markup.
+func (ix *index) snippet(doc int, terms []string) string {
+	wanted := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		wanted[t] = true
+	}
+
+	for _, field := range ix.fields[doc] {
+		tokens := tokenize(field)
+		first := -1
+		for i, t := range tokens {
+			if wanted[t.term] {
+				first = i
+				break
+			}
+		}
+		if first < 0 {
+			continue
+		}
+
+		start := wordBoundary(field, tokens[first].start-snippetRadius, -1)
+		if start > 0 {
+			start++ // past the space
+		}
+		end := wordBoundary(field, tokens[first].end+snippetRadius, 1)
+		var b strings.Builder
+		if start > 0 {
+			b.WriteString("…")
+		}
+		pos := start
+		for _, t := range tokens {
+			if t.start < start || t.end > end || !wanted[t.term] {
+				continue
+			}
+			b.WriteString(html.EscapeString(field[pos:t.start]))
+			b.WriteString(highlightOpen)
+			b.WriteString(html.EscapeString(field[t.start:t.end]))
+			b.WriteString(highlightClose)
+			pos = t.end
+		}
+		b.WriteString(html.EscapeString(field[pos:end]))
+		if end < len(field) {
+			b.WriteString("…")
+		}
+		return strings.TrimSpace(b.String())
+	}
+	return ""
+}
+
+// wordBoundary moves pos in dir until it is at a space or at an end of s
+func wordBoundary(s string, pos, dir int) int {
+	if pos <= 0 {
+		return 0
+	}
+	if pos >= len(s) {
+		return len(s)
+	}
+	for pos > 0 && pos < len(s) && !utf8.RuneStart(s[pos]) {
+		pos += dir
+	}
+	for pos > 0 && pos < len(s) && s[pos] != ' ' {
+		pos += dir
+	}
+	return pos
+}
+
+type token struct {
+	term       string // lower case
+	start, end int    // bytes of the original text
+}
+
+// tokenize splits text into runs of letters and digits
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// terms of the bare words of a query, without repeats
+func terms(words []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, w := range words {
+		for _, t := range tokenize(w) {
+			if !seen[t.term] {
+				seen[t.term] = true
+				result = append(result, t.term)
+			}
+		}
+	}
+	return result
+}
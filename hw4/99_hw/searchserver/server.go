@@ -0,0 +1,461 @@
+// Package searchserver is the search backend SearchClient talks to: users of
+// dataset.xml behind an http.Handler.
+//
+//	GET /?limit=10&offset=0&query=Boyd&order_field=Age&order_by=-1
+//	AccessToken: <token>
+//
+// query, order_field and fields use the language of hw4/query:
+//
+//	GET /?query=age>=30+gender:female&order_field=-Age,Name&fields=Id,Name
+//
+// Bare words of query are a case-insensitive full-text search over the text
+// fields of a row, every word must be found. Found users are ranked by BM25 in
+// Score, order_field=Relevance puts the best first, and Snippet shows the
+// match in <em></em>, the rest of it is HTML-escaped.
+//
+// The answer is a JSON list of users, with fields only the listed fields of
+// each. If there are more users, the X-Next-Cursor header holds an opaque
+// token, passed as cursor=<token> with the same query and order it returns
+// the next page. Answers carry an ETag, a request with a matching
+// If-None-Match gets 304 without a body.
+//
+// Without limit a page has DefaultLimit users, limit=0 gives an empty one.
+//
+// Failures are {"Error": "...", "Detail": "..."} with status 400 for bad
+// parameters, 401 for a missing, unknown or expired token, 403 if the token
+// lacks a scope, 429 with Retry-After over its rate limit and 500 if the
+// answer can't be encoded. A dataset that fails to reload keeps answering
+// with the users read before, Dataset.Err tells why. Tokens are checked only
+// with SetTokens.
+package searchserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hw4/query"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OrderByAsc  = -1
+	OrderByAsIs = 0
+	OrderByDesc = 1
+
+	DefaultLimit = 25
+)
+
+// Error codes sent in ErrorResponse, SearchClient knows ErrorBadOrderField
+const (
+	ErrorBadOrderField = "ErrorBadOrderField"
+	ErrorBadOrderBy    = "ErrorBadOrderBy"
+	ErrorBadLimit      = "ErrorBadLimit"
+	ErrorBadOffset     = "ErrorBadOffset"
+	ErrorBadQuery      = "ErrorBadQuery"
+	ErrorBadFields     = "ErrorBadFields"
+	ErrorBadCursor     = "ErrorBadCursor"
+)
+
+// NextCursorHeader carries the cursor of the next page
+const NextCursorHeader = "X-Next-Cursor"
+
+// ErrorResponse is SearchErrorResponse of the client, Detail is the parser
+// error for the query language parameters
+type ErrorResponse struct {
+	Error  string
+	Detail string `json:",omitempty"`
+}
+
+// RequestError is a bad search parameter, it is answered with 400
+type RequestError struct {
+	Code string
+	Err  error // the reason, if there is more to say than Code
+}
+
+func (e *RequestError) Error() string {
+	return e.Code
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+type Request struct {
+	Limit      int // 0 - all users
+	Offset     int
+	Query      string   // terms of hw4/query, bare words are a full-text search
+	OrderField string   // sort keys of Id, Age and Name, "-Age,Name", empty means Name
+	OrderBy    int      // direction of the keys without + or -
+	Fields     []string // projection, empty means all fields
+	Cursor     string   // NextCursor of the previous page, Offset counts after it
+}
+
+// Page is a result of SearchPage, NextCursor is empty on the last page
+type Page struct {
+	Users      []User
+	NextCursor string
+}
+
+var (
+	userSchema = &query.Schema{
+		Fields: map[string]query.Kind{
+			"Id":     query.Int,
+			"Name":   query.String,
+			"Age":    query.Int,
+			"About":  query.String,
+			"Gender": query.String,
+		},
+		Text: []string{"Name", "About"},
+	}
+	sortSchema = &query.Schema{
+		Fields: map[string]query.Kind{"Id": query.Int, "Age": query.Int, "Name": query.String, "Relevance": query.Int},
+	}
+)
+
+type Server struct {
+	dataset *Dataset
+	tokens  *TokenStore
+}
+
+// New loads the dataset once, later it is reloaded when the file changes
+func New(datasetPath string) (*Server, error) {
+	dataset, err := LoadDataset(datasetPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{dataset: dataset}, nil
+}
+
+func (s *Server) Dataset() *Dataset {
+	return s.dataset
+}
+
+// SetTokens makes the server accept only the tokens of the store, without it
+// any non-empty AccessToken is fine. Call it before serving.
+func (s *Server) SetTokens(tokens *TokenStore) {
+	s.tokens = tokens
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, err := s.authorize(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rq, err := parseRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if token != nil && hasWords(rq.Query) {
+		if err := checkScope(token, ScopeFullText); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	// limit=0 is an empty page, the search still checks the parameters
+	empty := rq.Limit == 0
+	if empty {
+		rq.Limit = 1
+	}
+	page, err := s.SearchPage(rq)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if empty {
+		page = Page{Users: []User{}}
+	}
+
+	var j []byte
+	if len(rq.Fields) > 0 {
+		j, err = json.Marshal(project(page.Users, rq.Fields))
+	} else {
+		j, err = json.Marshal(page.Users)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if page.NextCursor != "" {
+		w.Header().Set(NextCursorHeader, page.NextCursor)
+	}
+	etag := responseETag(j, page.NextCursor)
+	w.Header().Set("ETag", etag)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// responseETag is a hash of everything the client gets, so it changes
+// exactly when the answer does, e.g. after a reload of the dataset
+func responseETag(body []byte, cursor string) string {
+	h := sha256.New()
+	h.Write(body)
+	h.Write([]byte(cursor))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatch checks an If-None-Match list, weak tags compare as strong ones
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize returns the token of the request, nil if there is no TokenStore
+func (s *Server) authorize(r *http.Request) (*Token, error) {
+	token := r.Header.Get("AccessToken")
+	if token == "" {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Code: ErrorBadToken, Detail: "no AccessToken"}
+	}
+	if s.tokens == nil {
+		return nil, nil
+	}
+	return s.tokens.Authorize(token, ScopeSearch)
+}
+
+// hasWords tells if the query needs the full-text scope, a broken query is
+// reported by the search itself
+func hasWords(input string) bool {
+	q, err := query.Parse(input, userSchema)
+	return err == nil && len(terms(q.Words())) > 0
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	resp := ErrorResponse{Error: err.Error()}
+	switch e := err.(type) {
+	case *RequestError:
+		status = http.StatusBadRequest
+		if e.Err != nil {
+			resp.Detail = e.Err.Error()
+		}
+	case *AuthError:
+		status, resp.Detail = e.Status, e.Detail
+		if e.RetryAfter > 0 {
+			seconds := int64((e.RetryAfter + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		}
+	}
+	j, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(j)
+}
+
+// parseRequest reads the query parameters, missing ones get their defaults
+func parseRequest(r *http.Request) (Request, error) {
+	rq := Request{
+		Query:      r.FormValue("query"),
+		OrderField: r.FormValue("order_field"),
+		Cursor:     r.FormValue("cursor"),
+	}
+
+	var err error
+	if rq.Limit, err = intParam(r, "limit", DefaultLimit); err != nil || rq.Limit < 0 {
+		return rq, &RequestError{Code: ErrorBadLimit}
+	}
+	if rq.Offset, err = intParam(r, "offset", 0); err != nil || rq.Offset < 0 {
+		return rq, &RequestError{Code: ErrorBadOffset}
+	}
+	if rq.OrderBy, err = intParam(r, "order_by", OrderByAsIs); err != nil {
+		return rq, &RequestError{Code: ErrorBadOrderBy}
+	}
+	if rq.Fields, err = query.ParseFields(r.FormValue("fields"), userSchema); err != nil {
+		return rq, &RequestError{ErrorBadFields, err}
+	}
+	return rq, nil
+}
+
+func intParam(r *http.Request, name string, def int) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+var lessFuncs = map[string]func(a, b *User) bool{
+	"Id":   func(a, b *User) bool { return a.Id < b.Id },
+	"Age":  func(a, b *User) bool { return a.Age < b.Age },
+	"Name": func(a, b *User) bool { return a.Name < b.Name },
+	// ascending relevance goes from the best match
+	"Relevance": func(a, b *User) bool { return a.Score > b.Score },
+}
+
+// sortKey is a query.SortKey with the direction of the request applied
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+type sortKeys []sortKey
+
+func (keys sortKeys) less(a, b *User) bool {
+	for _, key := range keys {
+		x, y := a, b
+		if key.desc {
+			x, y = b, a
+		}
+		less := lessFuncs[key.field]
+		if less(x, y) {
+			return true
+		}
+		if less(y, x) {
+			return false
+		}
+	}
+	return false
+}
+
+// String is the canonical form cursors are bound to, "-Age,+Id"
+func (keys sortKeys) String() string {
+	s := ""
+	for i, key := range keys {
+		if i > 0 {
+			s += ","
+		}
+		if key.desc {
+			s += "-" + key.field
+		} else {
+			s += "+" + key.field
+		}
+	}
+	return s
+}
+
+// Search filters, sorts and pages the users of the dataset
+func (s *Server) Search(rq Request) ([]User, error) {
+	page, err := s.SearchPage(rq)
+	return page.Users, err
+}
+
+// SearchPage is Search that also returns the cursor of the next page
+func (s *Server) SearchPage(rq Request) (Page, error) {
+	switch rq.OrderBy {
+	case OrderByAsc, OrderByAsIs, OrderByDesc:
+	default:
+		return Page{}, &RequestError{Code: ErrorBadOrderBy}
+	}
+	if rq.OrderField == "" {
+		rq.OrderField = "Name"
+	}
+	keys, err := parseSortKeys(rq)
+	if err != nil {
+		return Page{}, err
+	}
+	q, err := query.Parse(rq.Query, userSchema)
+	if err != nil {
+		return Page{}, &RequestError{ErrorBadQuery, err}
+	}
+	var after *User
+	if rq.Cursor != "" {
+		if after, err = decodeCursor(rq.Cursor, keys.String(), rq.Query); err != nil {
+			return Page{}, &RequestError{ErrorBadCursor, err}
+		}
+	}
+
+	// the snapshot is shared between requests, filterUsers always copies it
+	users := filterUsers(s.dataset, q)
+	sort.SliceStable(users, func(i, j int) bool {
+		return keys.less(&users[i], &users[j])
+	})
+	if after != nil {
+		users = users[seek(users, keys, after):]
+	}
+
+	if rq.Offset >= len(users) {
+		return Page{Users: []User{}}, nil
+	}
+	users = users[rq.Offset:]
+	page := Page{Users: users}
+	if rq.Limit > 0 && rq.Limit < len(users) {
+		page.Users = users[:rq.Limit]
+		page.NextCursor = encodeCursor(&page.Users[rq.Limit-1], keys.String(), rq.Query)
+	}
+	return page, nil
+}
+
+// parseSortKeys drops the keys left as is and ends the order with Id, so that
+// it is total and a cursor points to one place in it. Without keys users go
+// by Id, the order of dataset.xml.
+func parseSortKeys(rq Request) (sortKeys, error) {
+	parsed, err := query.ParseSort(rq.OrderField, sortSchema)
+	if err != nil {
+		return nil, &RequestError{ErrorBadOrderField, err}
+	}
+	keys := make(sortKeys, 0, len(parsed)+1)
+	hasId := false
+	for _, key := range parsed {
+		switch {
+		case key.Dir == query.Desc, key.Dir == query.Default && rq.OrderBy == OrderByDesc:
+			keys = append(keys, sortKey{key.Field, true})
+		case key.Dir == query.Asc, key.Dir == query.Default && (rq.OrderBy == OrderByAsc || key.Field == "Relevance"):
+			keys = append(keys, sortKey{key.Field, false})
+		default:
+			continue
+		}
+		hasId = hasId || key.Field == "Id"
+	}
+	if !hasId {
+		keys = append(keys, sortKey{"Id", false})
+	}
+	return keys, nil
+}
+
+// filterUsers searches the bare words of q in the full-text index, the
+// users found get their Score and Snippet
+func filterUsers(dataset *Dataset, q *query.Query) []User {
+	users, index := dataset.snapshot()
+	words := terms(q.Words())
+	if len(words) == 0 {
+		result := make([]User, 0, len(users))
+		for i := range users {
+			if q.MatchFields(&users[i]) {
+				result = append(result, users[i])
+			}
+		}
+		return result
+	}
+
+	scores := index.search(words)
+	result := make([]User, 0, len(scores))
+	for i := range users {
+		score, ok := scores[i]
+		if !ok || !q.MatchFields(&users[i]) {
+			continue
+		}
+		u := users[i]
+		u.Score = score
+		u.Snippet = index.snippet(i, words)
+		result = append(result, u)
+	}
+	return result
+}
+
+// project keeps only the listed fields of each user
+func project(users []User, fields []string) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(users))
+	for i := range users {
+		item := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			item[field] = users[i].Value(field)
+		}
+		result = append(result, item)
+	}
+	return result
+}
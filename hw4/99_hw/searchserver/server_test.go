@@ -0,0 +1,370 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"hw4/searchtest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDataset = `<?xml version="1.0" encoding="UTF-8" ?>
+<root>
+  <row><id>0</id><age>22</age><first_name>Boyd</first_name><last_name>Wolf</last_name><gender>male</gender><about>Nulla cillum</about></row>
+  <row><id>1</id><age>21</age><first_name>Hilda</first_name><last_name>Mayer</last_name><gender>female</gender><about>Sit commodo</about></row>
+  <row><id>2</id><age>40</age><first_name>Brooks</first_name><last_name>Aguilar</last_name><gender>male</gender><about>Velit cillum</about></row>
+</root>`
+
+func writeDataset(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func get(t *testing.T, h http.Handler, query, token string) (int, []byte) {
+	r := httptest.NewRequest("GET", "/?"+query, nil)
+	if token != "" {
+		r.Header.Set("AccessToken", token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, w.Body.Bytes()
+}
+
+func ids(t *testing.T, body []byte) []int {
+	users := []User{}
+	if err := json.Unmarshal(body, &users); err != nil {
+		t.Fatalf("bad body %s: %s", body, err)
+	}
+	result := make([]int, 0, len(users))
+	for _, u := range users {
+		result = append(result, u.Id)
+	}
+	return result
+}
+
+func TestSearch(t *testing.T) {
+	srv, err := New(writeDataset(t, testDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Query string
+		Ids   []int
+	}{
+		{"", []int{0, 1, 2}},
+		{"order_field=Age&order_by=-1", []int{1, 0, 2}},
+		{"order_field=Age&order_by=1", []int{2, 0, 1}},
+		{"order_by=-1", []int{0, 2, 1}}, // Name by default
+		{"order_field=Id&order_by=1&limit=2", []int{2, 1}},
+		{"order_field=Id&order_by=1&limit=2&offset=2", []int{0}},
+		{"offset=3", []int{}},
+		{"limit=0", []int{}},
+		{"query=cillum", []int{0, 2}},
+		{"query=Hilda+Mayer", []int{1}},
+		{"query=nobody", []int{}},
+		{"query=age>=22+gender:male", []int{0, 2}},
+		{"query=about~CILLUM+age<30", []int{0}},
+		{"order_field=-Age,Id&order_by=-1", []int{2, 0, 1}},
+		{"order_field=Age,-Id", []int{2, 1, 0}}, // Age is left as is
+		{"order_field=-Age&limit=1&offset=1", []int{0}},
+	}
+	for caseNum, item := range cases {
+		code, body := get(t, srv, item.Query, "token")
+		if code != http.StatusOK {
+			t.Errorf("[%d] unexpected status %d: %s", caseNum, code, body)
+			continue
+		}
+		if got := ids(t, body); !reflect.DeepEqual(got, item.Ids) {
+			t.Errorf("[%d] %s: got %v, expected %v", caseNum, item.Query, got, item.Ids)
+		}
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	srv, err := New(writeDataset(t, testDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := get(t, srv, "", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", code)
+	}
+
+	cases := []struct {
+		Query string
+		Error string
+	}{
+		{"order_field=About", ErrorBadOrderField},
+		{"order_by=2", ErrorBadOrderBy},
+		{"order_by=x", ErrorBadOrderBy},
+		{"limit=-1", ErrorBadLimit},
+		{"limit=0&order_field=About", ErrorBadOrderField},
+		{"offset=x", ErrorBadOffset},
+		{"order_field=Age,About", ErrorBadOrderField},
+		{"query=age>x", ErrorBadQuery},
+		{"fields=Id,Salary", ErrorBadFields},
+	}
+	for caseNum, item := range cases {
+		code, body := get(t, srv, item.Query, "token")
+		errResp := ErrorResponse{}
+		json.Unmarshal(body, &errResp)
+		if code != http.StatusBadRequest || errResp.Error != item.Error {
+			t.Errorf("[%d] %s: got %d %s, expected %s", caseNum, item.Query, code, body, item.Error)
+		}
+	}
+
+	_, body := get(t, srv, "query=age>=30+height>2", "token")
+	if expected := `{"Error":"ErrorBadQuery","Detail":"col 9: unknown field \"height\""}`; string(body) != expected {
+		t.Errorf("got %s, expected %s", body, expected)
+	}
+
+	if _, err := New(filepath.Join(t.TempDir(), "missing.xml")); err == nil {
+		t.Errorf("expected an error for a missing dataset")
+	}
+}
+
+func TestProjection(t *testing.T) {
+	srv, err := New(writeDataset(t, testDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, body := get(t, srv, "query=Hilda&fields=id,Gender", "token")
+	if expected := `[{"Gender":"female","Id":1}]`; string(body) != expected {
+		t.Errorf("got %s, expected %s", body, expected)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	path := writeDataset(t, testDataset)
+	srv, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Dataset().ReloadInterval = 0
+
+	rq := Request{Limit: 1, OrderField: "Age", OrderBy: OrderByDesc}
+	page, err := srv.SearchPage(rq)
+	if err != nil || page.NextCursor == "" || page.Users[0].Id != 2 {
+		t.Fatalf("unexpected first page %+v %v", page, err)
+	}
+
+	// Boyd (22) is removed and an older user is added before the next page:
+	// the cursor continues after Brooks (40), an offset would skip Hilda
+	changed := `<root>
+	  <row><id>1</id><age>21</age><first_name>Hilda</first_name><last_name>Mayer</last_name></row>
+	  <row><id>2</id><age>40</age><first_name>Brooks</first_name><last_name>Aguilar</last_name></row>
+	  <row><id>3</id><age>50</age><first_name>Old</first_name><last_name>Timer</last_name></row>
+	</root>`
+	ioutil.WriteFile(path, []byte(changed), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	rq.Cursor = page.NextCursor
+	page, err = srv.SearchPage(rq)
+	if err != nil || len(page.Users) != 1 || page.Users[0].Id != 1 || page.NextCursor != "" {
+		t.Errorf("unexpected page after reload %+v %v", page, err)
+	}
+
+	// the cursor is served over HTTP as a header
+	rq = Request{Limit: 1}
+	page, _ = srv.SearchPage(rq)
+	r := httptest.NewRequest("GET", "/?limit=1", nil)
+	r.Header.Set("AccessToken", "token")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if got := w.Header().Get(NextCursorHeader); got != page.NextCursor {
+		t.Errorf("got cursor header %q, expected %q", got, page.NextCursor)
+	}
+
+	for _, query := range []string{
+		"cursor=" + page.NextCursor + "&order_field=Age&order_by=1",
+		"cursor=" + page.NextCursor + "&query=Hilda",
+		"cursor=!!!",
+		"cursor=bm90IGpzb24",
+	} {
+		code, body := get(t, srv, query, "token")
+		errResp := ErrorResponse{}
+		json.Unmarshal(body, &errResp)
+		if code != http.StatusBadRequest || errResp.Error != ErrorBadCursor {
+			t.Errorf("%s: got %d %s, expected %s", query, code, body, ErrorBadCursor)
+		}
+	}
+}
+
+func TestFullText(t *testing.T) {
+	long := strings.Repeat("lorem ipsum ", 20) + "the Golang gopher " + strings.Repeat("dolor sit ", 20)
+	srv, err := New(writeDataset(t, `<root>
+	  <row><id>0</id><age>30</age><first_name>Go</first_name><last_name>Pher</last_name><company>ACME</company><about>golang, Golang!</about></row>
+	  <row><id>1</id><age>40</age><first_name>Rob</first_name><last_name>Pike</last_name><company>Bell Labs</company><about>writes golang and plan9</about></row>
+	  <row><id>2</id><age>50</age><first_name>Ken</first_name><last_name>Thompson</last_name><company>Bell Labs</company><about>`+long+`</about></row>
+	  <row><id>3</id><age>60</age><first_name>Дмитрий</first_name><last_name>Иванов</last_name><about>пишет на Go</about></row>
+	  <row><id>4</id><age>70</age><first_name>Mallory</first_name><about>&lt;script&gt;xss&lt;/script&gt; &amp; co</about></row>
+	</root>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Query    string
+		Ids      []int
+		Snippets []string
+	}{
+		{Query: "query=GOLANG&order_field=Relevance", Ids: []int{0, 1, 2}, Snippets: []string{
+			"<em>golang</em>, <em>Golang</em>!",
+			"writes <em>golang</em> and plan9",
+			"…lorem ipsum lorem ipsum lorem ipsum lorem ipsum lorem ipsum the <em>Golang</em> gopher dolor sit dolor sit dolor sit dolor sit dolor sit dolor…",
+		}},
+		{Query: "query=golang+bell", Ids: []int{1, 2}},
+		{Query: "query=bell+age>40", Ids: []int{2}},
+		{Query: "query=acme", Ids: []int{0}, Snippets: []string{"<em>ACME</em>"}},
+		{Query: "query=дмитрий", Ids: []int{3}, Snippets: []string{"<em>Дмитрий</em> Иванов"}},
+		{Query: "query=xss", Ids: []int{4}, Snippets: []string{"&lt;script&gt;<em>xss</em>&lt;/script&gt; &amp; co"}},
+		{Query: "query=gol", Ids: []int{}},
+		{Query: "query=!!!", Ids: []int{0, 1, 2, 3, 4}},
+	}
+	for caseNum, item := range cases {
+		code, body := get(t, srv, item.Query, "token")
+		users := []User{}
+		if err := json.Unmarshal(body, &users); err != nil || code != http.StatusOK {
+			t.Fatalf("[%d] %d %s", caseNum, code, body)
+		}
+		gotIds, snippets := []int{}, []string{}
+		for _, u := range users {
+			gotIds = append(gotIds, u.Id)
+			if u.Snippet != "" {
+				snippets = append(snippets, u.Snippet)
+			}
+		}
+		if !reflect.DeepEqual(gotIds, item.Ids) {
+			t.Errorf("[%d] %s: got %v, expected %v", caseNum, item.Query, gotIds, item.Ids)
+		}
+		if item.Snippets != nil && !reflect.DeepEqual(snippets, item.Snippets) {
+			t.Errorf("[%d] %s: got snippets\n%q\nexpected\n%q", caseNum, item.Query, snippets, item.Snippets)
+		}
+	}
+
+	// relevance is a sort key like the others
+	rq := Request{Query: "golang", OrderField: "-Relevance", Limit: 1}
+	page, err := srv.SearchPage(rq)
+	if err != nil || page.Users[0].Id != 2 || page.Users[0].Score <= 0 {
+		t.Fatalf("unexpected page %+v %v", page, err)
+	}
+	rq.Cursor = page.NextCursor
+	if page, _ = srv.SearchPage(rq); page.Users[0].Id != 1 {
+		t.Errorf("cursor by relevance went to %+v", page.Users)
+	}
+}
+
+func TestETag(t *testing.T) {
+	path := writeDataset(t, testDataset)
+	srv, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Dataset().ReloadInterval = 0
+
+	request := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?limit=1", nil)
+		r.Header.Set("AccessToken", "token")
+		r.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	first := request("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", first.Code, etag)
+	}
+	for _, header := range []string{etag, `"other", W/` + etag, "*"} {
+		if w := request(header); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected 304, got %d %s", header, w.Code, w.Body)
+		}
+	}
+	if w := request(`"other"`); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for another ETag, got %d", w.Code)
+	}
+
+	ioutil.WriteFile(path, []byte(`<root><row><id>7</id></row></root>`), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if w := request(etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("expected a new answer after reload, got %d %s", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestConformance(t *testing.T) {
+	srv, err := New("../dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	searchtest.RunServerSuite(t, srv, "token")
+}
+
+func TestHotReload(t *testing.T) {
+	path := writeDataset(t, testDataset)
+	srv, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Dataset().ReloadInterval = 0
+
+	one := `<root><row><id>7</id><first_name>New</first_name><last_name>User</last_name></row></root>`
+	ioutil.WriteFile(path, []byte(one), 0644)
+	// file systems with coarse timestamps must still see the change
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if _, body := get(t, srv, "", "token"); !reflect.DeepEqual(ids(t, body), []int{7}) {
+		t.Errorf("dataset was not reloaded: %s", body)
+	}
+
+	ioutil.WriteFile(path, []byte("<root><row>"), 0644)
+	os.Chtimes(path, later.Add(time.Minute), later.Add(time.Minute))
+	if _, body := get(t, srv, "", "token"); !reflect.DeepEqual(ids(t, body), []int{7}) {
+		t.Errorf("a broken file must not replace the dataset: %s", body)
+	}
+	if srv.Dataset().Err() == nil {
+		t.Errorf("expected the reload error to be kept")
+	}
+}
+
+func TestReloadDoesNotBlock(t *testing.T) {
+	f := &watchedFile{path: writeDataset(t, testDataset)}
+	started, release := make(chan struct{}), make(chan struct{})
+	go f.reloadIfChanged(0, func(string) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	// a reader during the load goes on with the old content
+	done := make(chan struct{})
+	go func() {
+		f.reloadIfChanged(0, func(string) error {
+			t.Errorf("a second load must not start while one is running")
+			return nil
+		})
+		f.err()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("reloadIfChanged blocked on the load in progress")
+	}
+	close(release)
+}
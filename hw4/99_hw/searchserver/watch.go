@@ -0,0 +1,86 @@
+package searchserver
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// watchedFile reloads a file when its modification time or size changes,
+// Dataset and TokenStore keep their content in memory this way
+type watchedFile struct {
+	path string
+
+	// loadMu runs one load at a time, mu guards the fields below and is never
+	// held during a load, so readers keep the old content meanwhile
+	loadMu  sync.Mutex
+	mu      sync.Mutex
+	loading int
+	checked time.Time
+	modTime time.Time
+	size    int64
+	lastErr error
+}
+
+// reload calls load unconditionally, after the load in progress if any
+func (f *watchedFile) reload(load func(path string) error) error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.loading++
+	f.mu.Unlock()
+	return f.load(info, load)
+}
+
+// reloadIfChanged stats the file at most once per interval. While another
+// load runs it returns at once and the caller gets the old content.
+func (f *watchedFile) reloadIfChanged(interval time.Duration, load func(path string) error) {
+	f.mu.Lock()
+	now := time.Now()
+	if f.loading > 0 || now.Sub(f.checked) < interval {
+		f.mu.Unlock()
+		return
+	}
+	f.checked = now
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.lastErr = err
+		f.mu.Unlock()
+		return
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		f.mu.Unlock()
+		return
+	}
+	f.loading++
+	f.mu.Unlock()
+
+	f.load(info, load)
+}
+
+// err is the error of the last reload, nil if it succeeded
+func (f *watchedFile) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastErr
+}
+
+// load is called with f.loading counted, a failed load keeps the old content
+func (f *watchedFile) load(info os.FileInfo, load func(path string) error) error {
+	f.loadMu.Lock()
+	err := load(f.path)
+	f.loadMu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loading--
+	f.lastErr = err
+	if err != nil {
+		return err
+	}
+	f.modTime, f.size, f.checked = info.ModTime(), info.Size(), time.Now()
+	return nil
+}
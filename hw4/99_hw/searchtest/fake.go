@@ -0,0 +1,136 @@
+// Package searchtest helps to test the search protocol of hw4: Server is a
+// scriptable fake backend for clients, RunClientSuite and RunServerSuite are
+// conformance suites for any client or server implementation.
+package searchtest
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Response is a scripted answer of Server
+type Response struct {
+	Status int // 0 means 200
+	Body   string
+	Header http.Header
+	// Delay before answering, a client that gives up earlier stops the wait
+	Delay time.Duration
+	// Reset closes the connection without an answer
+	Reset bool
+}
+
+// JSON answers v marshalled
+func JSON(status int, v interface{}) Response {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return Response{Status: status, Body: string(body), Header: http.Header{"Content-Type": {"application/json"}}}
+}
+
+// Malformed answers a body that is not JSON
+func Malformed(status int) Response {
+	return Response{Status: status, Body: `{"Error": "cut in the mid`}
+}
+
+// Slow is r after a delay
+func Slow(d time.Duration, r Response) Response {
+	r.Delay = d
+	return r
+}
+
+// Reset drops the connection
+func Reset() Response {
+	return Response{Reset: true}
+}
+
+// Server is an httptest.Server answering the scripted responses in order,
+// after them it passes requests to the fallback handler
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	script   []Response
+	fallback http.Handler
+	requests []*http.Request
+}
+
+// NewServer starts a server, fallback may be nil: requests beyond the script
+// get 500 then
+func NewServer(fallback http.Handler) *Server {
+	s := &Server{fallback: fallback}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Enqueue adds responses to the script
+func (s *Server) Enqueue(responses ...Response) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, responses...)
+	return s
+}
+
+// Requests are the requests received so far, bodies are not kept
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Clone(r.Context()))
+	scripted := len(s.script) > 0
+	var resp Response
+	if scripted {
+		resp, s.script = s.script[0], s.script[1:]
+	}
+	s.mu.Unlock()
+
+	if !scripted {
+		if s.fallback == nil {
+			http.Error(w, "searchtest: no scripted response", http.StatusInternalServerError)
+			return
+		}
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	if resp.Delay > 0 {
+		timer := time.NewTimer(resp.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if resp.Reset {
+		reset(w)
+		return
+	}
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	if resp.Status != 0 {
+		w.WriteHeader(resp.Status)
+	}
+	w.Write([]byte(resp.Body))
+}
+
+// reset closes the connection with RST instead of FIN where it can
+func reset(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
@@ -0,0 +1,102 @@
+package searchtest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, url, token string) (*http.Response, string) {
+	t.Helper()
+	r, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("AccessToken", token)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestServerScript(t *testing.T) {
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	s := NewServer(fallback).Enqueue(
+		Response{Status: http.StatusUnauthorized, Body: `{"Error": "ErrorBadToken"}`},
+		Response{Body: `[]`, Header: http.Header{"X-Next-Cursor": {"abc"}}},
+		JSON(http.StatusBadRequest, map[string]string{"Error": "ErrorBadOrderField"}),
+		Malformed(http.StatusOK),
+	)
+	defer s.Close()
+
+	cases := []struct {
+		Status int
+		Body   string
+		Cursor string
+	}{
+		{http.StatusUnauthorized, `{"Error": "ErrorBadToken"}`, ""},
+		{http.StatusOK, `[]`, "abc"},
+		{http.StatusBadRequest, `{"Error":"ErrorBadOrderField"}`, ""},
+		{http.StatusOK, `{"Error": "cut in the mid`, ""},
+		// the script is over
+		{http.StatusTeapot, "", ""},
+	}
+	for caseNum, item := range cases {
+		resp, body := get(t, s.URL+"?limit=2", "token")
+		if resp.StatusCode != item.Status || body != item.Body || resp.Header.Get("X-Next-Cursor") != item.Cursor {
+			t.Errorf("[%d] got %d %q cursor %q, expected %d %q cursor %q", caseNum,
+				resp.StatusCode, body, resp.Header.Get("X-Next-Cursor"), item.Status, item.Body, item.Cursor)
+		}
+	}
+
+	requests := s.Requests()
+	if len(requests) != len(cases) {
+		t.Fatalf("expected %d requests recorded, got %d", len(cases), len(requests))
+	}
+	for i, r := range requests {
+		if r.Header.Get("AccessToken") != "token" || r.FormValue("limit") != "2" {
+			t.Errorf("[%d] request recorded without its token or parameters: %s %v", i, r.URL, r.Header)
+		}
+	}
+}
+
+func TestServerNoFallback(t *testing.T) {
+	s := NewServer(nil)
+	defer s.Close()
+
+	if resp, _ := get(t, s.URL, ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500 without a script and a fallback, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerFailures(t *testing.T) {
+	s := NewServer(nil).Enqueue(Reset(), Slow(time.Hour, JSON(http.StatusOK, []User{})))
+	defer s.Close()
+
+	if _, err := http.Get(s.URL); err == nil {
+		t.Errorf("expected a connection error after Reset")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r, _ := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	start := time.Now()
+	if _, err := http.DefaultClient.Do(r); err == nil {
+		t.Errorf("expected a timeout for a slow response")
+	}
+	// Close waits for the handler, a client that gave up must not keep it for the delay
+	s.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("slow response outlived its client: %s", elapsed)
+	}
+}
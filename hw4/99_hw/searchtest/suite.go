@@ -0,0 +1,278 @@
+package searchtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Request, User and Result mirror the types of SearchClient, the suite can't
+// import them from package main
+type Request struct {
+	Limit      int
+	Offset     int
+	Query      string
+	OrderField string
+	OrderBy    int
+}
+
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+}
+
+type Result struct {
+	Users    []User
+	NextPage bool
+}
+
+// Client is a client under test, its errors must wrap the errors below
+type Client interface {
+	FindUsers(ctx context.Context, req Request) (*Result, error)
+}
+
+// Kinds of client errors the suite tells apart, context errors are expected
+// as they are
+var (
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrBadOrderField = errors.New("bad order field")
+	ErrBadRequest    = errors.New("bad request")
+	ErrServer        = errors.New("server error")
+	ErrBadResponse   = errors.New("bad response")
+	ErrTimeout       = errors.New("timeout")
+	ErrUnavailable   = errors.New("unavailable")
+)
+
+// RunClientSuite checks a client against scripted servers. newClient makes a
+// client of the server at url with a non-empty token.
+func RunClientSuite(t *testing.T, newClient func(url string) Client) {
+	users := []User{{Id: 1, Name: "Boyd Wolf", Age: 22}, {Id: 2, Name: "Hilda Mayer", Age: 21}}
+
+	run := func(name string, fn func(t *testing.T, s *Server, c Client)) {
+		t.Run(name, func(t *testing.T) {
+			s := NewServer(nil)
+			defer s.Close()
+			fn(t, s, newClient(s.URL))
+		})
+	}
+
+	run("Page", func(t *testing.T, s *Server, c Client) {
+		next := JSON(http.StatusOK, users)
+		next.Header.Set("X-Next-Cursor", "next")
+		s.Enqueue(next, JSON(http.StatusOK, users[:1]))
+
+		res, err := c.FindUsers(context.Background(), Request{Limit: 2, Offset: 3, Query: "age>20", OrderField: "Age", OrderBy: 1})
+		if err != nil || !reflect.DeepEqual(res.Users, users) || !res.NextPage {
+			t.Errorf("got %+v %v, expected the users and a next page", res, err)
+		}
+		res, err = c.FindUsers(context.Background(), Request{Limit: 100})
+		if err != nil || len(res.Users) != 1 || res.NextPage {
+			t.Errorf("got %+v %v, expected the last page", res, err)
+		}
+
+		requests := s.Requests()
+		if len(requests) != 2 {
+			t.Fatalf("expected 2 requests, got %d", len(requests))
+		}
+		expected := url.Values{
+			"limit": {"2"}, "offset": {"3"}, "query": {"age>20"}, "order_field": {"Age"}, "order_by": {"1"},
+		}
+		for name, value := range expected {
+			if got := requests[0].URL.Query().Get(name); got != value[0] {
+				t.Errorf("parameter %s: got %q, expected %q", name, got, value[0])
+			}
+		}
+		if requests[0].Header.Get("AccessToken") == "" {
+			t.Errorf("no AccessToken header")
+		}
+		if limit, _ := strconv.Atoi(requests[1].URL.Query().Get("limit")); limit > 25 {
+			t.Errorf("limit %d over 25 sent", limit)
+		}
+	})
+
+	run("InvalidRequest", func(t *testing.T, s *Server, c Client) {
+		for _, req := range []Request{{Limit: -1}, {Offset: -1}} {
+			if _, err := c.FindUsers(context.Background(), req); err == nil {
+				t.Errorf("%+v: expected an error", req)
+			}
+		}
+		if n := len(s.Requests()); n != 0 {
+			t.Errorf("invalid requests must not be sent, got %d", n)
+		}
+	})
+
+	failures := []struct {
+		Name     string
+		Response Response
+		Kind     error
+	}{
+		{"Unauthorized", Response{Status: http.StatusUnauthorized}, ErrUnauthorized},
+		{"BadOrderField", JSON(http.StatusBadRequest, map[string]string{"Error": "ErrorBadOrderField"}), ErrBadOrderField},
+		{"BadRequest", JSON(http.StatusBadRequest, map[string]string{"Error": "ErrorSomethingNew"}), ErrBadRequest},
+		{"InternalError", Response{Status: http.StatusInternalServerError}, ErrServer},
+		{"Unavailable", Response{Status: http.StatusServiceUnavailable}, ErrServer},
+		{"MalformedError", Malformed(http.StatusBadRequest), ErrBadResponse},
+		{"MalformedResult", Malformed(http.StatusOK), ErrBadResponse},
+		{"ConnectionReset", Reset(), ErrUnavailable},
+	}
+	for _, item := range failures {
+		item := item
+		run(item.Name, func(t *testing.T, s *Server, c Client) {
+			s.Enqueue(item.Response)
+			res, err := c.FindUsers(context.Background(), Request{Limit: 1})
+			if !errors.Is(err, item.Kind) {
+				t.Errorf("got %+v %v, expected %v", res, err, item.Kind)
+			}
+		})
+	}
+
+	run("Deadline", func(t *testing.T, s *Server, c Client) {
+		s.Enqueue(Slow(time.Hour, JSON(http.StatusOK, users)))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if _, err := c.FindUsers(ctx, Request{Limit: 1}); !errors.Is(err, ErrTimeout) {
+			t.Errorf("expected %v, got %v", ErrTimeout, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("the deadline was ignored, took %s", elapsed)
+		}
+	})
+
+	run("Canceled", func(t *testing.T, s *Server, c Client) {
+		s.Enqueue(Slow(time.Hour, JSON(http.StatusOK, users)))
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		if _, err := c.FindUsers(ctx, Request{Limit: 1}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	})
+}
+
+// RunServerSuite checks the protocol of a search server, token must be
+// accepted by it. The dataset needs at least 5 users.
+func RunServerSuite(t *testing.T, h http.Handler, token string) {
+	get := func(t *testing.T, query, token string) (*httptest.ResponseRecorder, []User) {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		if token != "" {
+			r.Header.Set("AccessToken", token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		users := []User{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+				t.Fatalf("%s: bad body %s: %s", query, w.Body, err)
+			}
+		}
+		return w, users
+	}
+	ids := func(users []User) []int {
+		result := make([]int, 0, len(users))
+		for _, u := range users {
+			result = append(result, u.Id)
+		}
+		return result
+	}
+
+	_, all := get(t, "order_field=Id&order_by=-1&limit=25", token)
+	if len(all) < 5 {
+		t.Fatalf("the suite needs at least 5 users, got %d", len(all))
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		if w, _ := get(t, "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 without a token, got %d", w.Code)
+		}
+	})
+
+	t.Run("Order", func(t *testing.T) {
+		orders := []struct {
+			Query string
+			Less  func(a, b User) bool
+		}{
+			{"order_field=Id&order_by=-1", func(a, b User) bool { return a.Id < b.Id }},
+			{"order_field=Id&order_by=1", func(a, b User) bool { return a.Id > b.Id }},
+			{"order_field=Age&order_by=-1", func(a, b User) bool { return a.Age < b.Age }},
+			{"order_field=&order_by=1", func(a, b User) bool { return a.Name > b.Name }},
+		}
+		for _, item := range orders {
+			w, users := get(t, item.Query, token)
+			if w.Code != http.StatusOK || !sort.SliceIsSorted(users, func(i, j int) bool { return item.Less(users[i], users[j]) }) {
+				t.Errorf("%s: got %d %v", item.Query, w.Code, ids(users))
+			}
+		}
+	})
+
+	t.Run("LimitOffset", func(t *testing.T) {
+		_, page := get(t, "order_field=Id&order_by=-1&limit=3&offset=2", token)
+		if !reflect.DeepEqual(ids(page), ids(all[2:5])) {
+			t.Errorf("got %v, expected %v", ids(page), ids(all[2:5]))
+		}
+		if _, users := get(t, "offset=100000", token); len(users) != 0 {
+			t.Errorf("offset past the end returned %d users", len(users))
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		name := all[0].Name
+		_, users := get(t, "query="+url.QueryEscape(`"`+name+`"`), token)
+		found := false
+		for _, u := range users {
+			found = found || u.Id == all[0].Id
+		}
+		if !found {
+			t.Errorf("query %q didn't find user %d: %v", name, all[0].Id, ids(users))
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		queries := map[string]string{
+			"order_field=Salary": "ErrorBadOrderField",
+			"limit=-1":           "",
+			"order_by=5":         "",
+		}
+		for query, code := range queries {
+			w, _ := get(t, query, token)
+			resp := struct{ Error string }{}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != http.StatusBadRequest || resp.Error == "" || code != "" && resp.Error != code {
+				t.Errorf("%s: got %d %s", query, w.Code, w.Body)
+			}
+		}
+	})
+
+	t.Run("Cursor", func(t *testing.T) {
+		w, users := get(t, "order_field=Id&order_by=-1&limit=2", token)
+		if w.Header().Get("X-Next-Cursor") == "" {
+			t.Skip("the server has no cursors")
+		}
+		// all is the first page of 25, the walk covers it and may go beyond
+		for cursor := w.Header().Get("X-Next-Cursor"); cursor != "" && len(users) < len(all); {
+			var page []User
+			w, page = get(t, "order_field=Id&order_by=-1&limit=2&cursor="+url.QueryEscape(cursor), token)
+			if w.Code != http.StatusOK || len(page) == 0 {
+				t.Fatalf("cursor %s: got %d %s", cursor, w.Code, w.Body)
+			}
+			users = append(users, page...)
+			cursor = w.Header().Get("X-Next-Cursor")
+		}
+		if len(users) > len(all) {
+			users = users[:len(all)]
+		}
+		if !reflect.DeepEqual(ids(users), ids(all)) {
+			t.Errorf("pages by cursor %v differ from %v", ids(users), ids(all))
+		}
+	})
+}